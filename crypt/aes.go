@@ -6,6 +6,38 @@ import (
 
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+)
+
+var (
+	// ErrPKCS7Padding PKCS7填充不合法，通常是密钥错误或密文被篡改
+	ErrPKCS7Padding = errors.New("pkcs7 padding invalid")
+	// ErrBlockSize 分组长度不合法
+	ErrBlockSize = errors.New("block size invalid")
+	// ErrDataLength 数据长度不是分组长度的整数倍
+	ErrDataLength = errors.New("data length is not a multiple of the block size")
+	// ErrIVLength 初始向量长度与分组长度不一致
+	ErrIVLength = errors.New("iv length must equal block size")
+)
+
+// GCM信封格式：version(1字节) | nonce(12字节) | ciphertext | tag(16字节)
+const (
+	// GCMVersion1 当前的GCM信封版本号
+	GCMVersion1 byte = 0x01
+	// GCMNonceSize GCM模式的nonce长度
+	GCMNonceSize = 12
+	// GCMTagSize GCM模式的认证标签长度
+	GCMTagSize = 16
+)
+
+var (
+	// ErrGCMEnvelope GCM信封格式错误
+	ErrGCMEnvelope = errors.New("gcm envelope invalid")
+	// ErrGCMVersion GCM信封版本不支持
+	ErrGCMVersion = errors.New("gcm envelope version unsupported")
+	// ErrGCMAuth GCM认证失败，密文或附加数据被篡改
+	ErrGCMAuth = errors.New("gcm message authentication failed")
 )
 
 // PKCS7Padding 填充
@@ -15,18 +47,49 @@ func PKCS7Padding(orig []byte, blockSize int) []byte {
 	return append(orig, padtext...)
 }
 
-// PKCS7UnPadding 解填充
+// PKCS7UnPadding 解填充，会校验所有填充字节
+//
+// Deprecated: 不知道分组长度，无法校验数据长度，请使用PKCS7UnPaddingBlock
 func PKCS7UnPadding(orig []byte) ([]byte, error) {
 	length := len(orig)
 	if length == 0 {
 		return nil, nil
 	}
-	unpadding := int(orig[length-1])
-	size := length - unpadding
-	if size < 0 {
-		return nil, errors.New("unpadding length invalid")
+	maxPadding := length
+	if maxPadding > 255 {
+		maxPadding = 255
+	}
+	return pkcs7UnPadding(orig, maxPadding)
+}
+
+// PKCS7UnPaddingBlock 校验并去除PKCS7填充。
+// 数据长度必须是分组长度的整数倍，填充字节的校验以常量时间完成，
+// 填充不合法时统一返回ErrPKCS7Padding，不泄露具体是哪个字节出错
+func PKCS7UnPaddingBlock(orig []byte, blockSize int) ([]byte, error) {
+	if blockSize <= 0 || blockSize > 255 {
+		return nil, ErrBlockSize
+	}
+	if len(orig) == 0 || len(orig)%blockSize != 0 {
+		return nil, ErrDataLength
+	}
+	return pkcs7UnPadding(orig, blockSize)
+}
+
+// pkcs7UnPadding 以常量时间校验填充长度在[1, maxPadding]之间，且所有填充字节都等于填充长度
+func pkcs7UnPadding(orig []byte, maxPadding int) ([]byte, error) {
+	length := len(orig)
+	padding := int(orig[length-1])
+	good := subtle.ConstantTimeLessOrEq(1, padding) & subtle.ConstantTimeLessOrEq(padding, maxPadding)
+	// 无论填充长度是多少，都检查末尾maxPadding个字节
+	for i := 1; i <= maxPadding; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i, padding)
+		equal := subtle.ConstantTimeByteEq(orig[length-i], byte(padding))
+		good &= 1 ^ (inPadding & (1 ^ equal))
 	}
-	return orig[:size], nil
+	if good != 1 {
+		return nil, ErrPKCS7Padding
+	}
+	return orig[:length-padding], nil
 }
 
 // AESEncryptCBC AES CBC模式加密
@@ -35,6 +98,9 @@ func AESEncryptCBC(key, iv, plaintext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(iv) != block.BlockSize() {
+		return nil, ErrIVLength
+	}
 	data := PKCS7Padding(plaintext, block.BlockSize())
 	ciphertext := make([]byte, len(data))
 	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, data)
@@ -47,9 +113,15 @@ func AESDecryptCBC(key, iv, ciphertext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(iv) != block.BlockSize() {
+		return nil, ErrIVLength
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, ErrDataLength
+	}
 	plaintext := make([]byte, len(ciphertext))
 	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
-	return PKCS7UnPadding(plaintext)
+	return PKCS7UnPaddingBlock(plaintext, block.BlockSize())
 }
 
 // AESEncryptECB AES ECB模式加密
@@ -74,9 +146,67 @@ func AESDecryptECB(key, ciphertext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, ErrDataLength
+	}
 	plaintext := make([]byte, len(ciphertext))
 	for bs, be := 0, block.BlockSize(); bs < len(ciphertext); bs, be = bs+block.BlockSize(), be+block.BlockSize() {
 		block.Decrypt(plaintext[bs:be], ciphertext[bs:be])
 	}
-	return PKCS7UnPadding(plaintext)
+	return PKCS7UnPaddingBlock(plaintext, block.BlockSize())
+}
+
+// AESEncryptGCM AES GCM模式加密，随机生成nonce，输出带版本号、nonce、tag的信封。
+// additionalData 为附加认证数据，不加密但参与认证，可以为nil
+func AESEncryptGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, GCMNonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, 0, 1+GCMNonceSize+len(plaintext)+GCMTagSize)
+	envelope = append(envelope, GCMVersion1)
+	envelope = append(envelope, nonce...)
+	return aead.Seal(envelope, nonce, plaintext, gcmAdditionalData(GCMVersion1, additionalData)), nil
+}
+
+// AESDecryptGCM AES GCM模式解密，校验信封版本与认证标签，被篡改时返回ErrGCMAuth
+func AESDecryptGCM(key, envelope, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < 1+GCMNonceSize+GCMTagSize {
+		return nil, ErrGCMEnvelope
+	}
+	version := envelope[0]
+	if version != GCMVersion1 {
+		return nil, ErrGCMVersion
+	}
+	nonce := envelope[1 : 1+GCMNonceSize]
+	ciphertext := envelope[1+GCMNonceSize:]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, gcmAdditionalData(version, additionalData))
+	if err != nil {
+		return nil, ErrGCMAuth
+	}
+	return plaintext, nil
+}
+
+// newGCM 根据key生成AES-GCM实例
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, GCMNonceSize)
+}
+
+// gcmAdditionalData 将版本号加入附加认证数据，防止版本字节被篡改
+func gcmAdditionalData(version byte, additionalData []byte) []byte {
+	ad := make([]byte, 0, 1+len(additionalData))
+	ad = append(ad, version)
+	return append(ad, additionalData...)
 }
@@ -1,6 +1,7 @@
 package crypt
 
 import (
+	"bytes"
 	"encoding/base64"
 	"testing"
 
@@ -56,3 +57,93 @@ func TestAESECB(t *testing.T) {
 	assert.Equal(t, plain, string(result2), "should equal")
 	assert.NoError(t, err)
 }
+
+func TestAESGCM(t *testing.T) {
+	plain := "test crypt"
+	key := "E15E2A40282E42E01163B2643C208505"
+	ad := []byte("merchant:Tencent")
+
+	envelope, err := AESEncryptGCM([]byte(key), []byte(plain), ad)
+	assert.NoError(t, err)
+	assert.Equal(t, GCMVersion1, envelope[0])
+	assert.Equal(t, 1+GCMNonceSize+len(plain)+GCMTagSize, len(envelope))
+
+	// 相同明文每次加密结果不同
+	envelope2, err := AESEncryptGCM([]byte(key), []byte(plain), ad)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Equal(envelope, envelope2))
+
+	result, err := AESDecryptGCM([]byte(key), envelope, ad)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, string(result), "should equal")
+
+	// 附加数据不一致
+	_, err = AESDecryptGCM([]byte(key), envelope, []byte("merchant:Other"))
+	assert.ErrorIs(t, err, ErrGCMAuth)
+
+	// 密文被篡改
+	tampered := append([]byte{}, envelope...)
+	tampered[len(tampered)-GCMTagSize-1] ^= 0x01
+	_, err = AESDecryptGCM([]byte(key), tampered, ad)
+	assert.ErrorIs(t, err, ErrGCMAuth)
+
+	// 版本号不支持
+	tampered = append([]byte{}, envelope...)
+	tampered[0] = 0x7f
+	_, err = AESDecryptGCM([]byte(key), tampered, ad)
+	assert.ErrorIs(t, err, ErrGCMVersion)
+
+	// 信封过短
+	_, err = AESDecryptGCM([]byte(key), envelope[:GCMNonceSize], ad)
+	assert.ErrorIs(t, err, ErrGCMEnvelope)
+}
+
+func TestPKCS7UnPaddingBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr error
+	}{
+		{"valid", []byte{'a', 'b', 'c', 'd', 4, 4, 4, 4}, []byte("abcd"), nil},
+		{"full block", []byte{4, 4, 4, 4}, []byte{}, nil},
+		{"single byte", []byte{'a', 'b', 'c', 1}, []byte("abc"), nil},
+		{"zero padding", []byte{'a', 'b', 'c', 0}, nil, ErrPKCS7Padding},
+		{"padding too long", []byte{'a', 'b', 'c', 5}, nil, ErrPKCS7Padding},
+		{"padding mismatch", []byte{'a', 'b', 3, 3, 'c', 2, 1, 3}, nil, ErrPKCS7Padding},
+		{"empty", []byte{}, nil, ErrDataLength},
+		{"not multiple", []byte{'a', 'b', 1}, nil, ErrDataLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := PKCS7UnPaddingBlock(tt.data, 4)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, result)
+		})
+	}
+
+	_, err := PKCS7UnPaddingBlock([]byte{1}, 0)
+	assert.ErrorIs(t, err, ErrBlockSize)
+}
+
+func TestAESDecryptCBCBadPadding(t *testing.T) {
+	key, iv := "E15E2A40282E42E0", "3B3B16BFE6A8A7CF"
+	ciphertext, err := AESEncryptCBC([]byte(key), []byte(iv), []byte("test crypt"))
+	assert.NoError(t, err)
+
+	// 密钥错误时填充校验失败
+	_, err = AESDecryptCBC([]byte("E15E2A40282E42E1"), []byte(iv), ciphertext)
+	assert.ErrorIs(t, err, ErrPKCS7Padding)
+
+	// 密文长度不合法
+	_, err = AESDecryptCBC([]byte(key), []byte(iv), ciphertext[:10])
+	assert.ErrorIs(t, err, ErrDataLength)
+
+	// IV长度不合法
+	_, err = AESDecryptCBC([]byte(key), []byte(iv)[:8], ciphertext)
+	assert.ErrorIs(t, err, ErrIVLength)
+}
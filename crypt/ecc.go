@@ -0,0 +1,315 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// GenerateECDSAKey 生成ECDSA密钥对，curve支持elliptic.P256()和elliptic.P384()
+func GenerateECDSAKey(curve elliptic.Curve) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	if err := checkCurve(curve); err != nil {
+		return nil, nil, err
+	}
+	privatekey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privatekey, &privatekey.PublicKey, nil
+}
+
+// GenerateECDSAKeyStr 生成ECDSA密钥对，并将密钥转成字符串形式
+func GenerateECDSAKeyStr(curve elliptic.Curve) (prikey, pubkey string, err error) {
+	privatekey, publickey, err := GenerateECDSAKey(curve)
+	if err != nil {
+		return "", "", err
+	}
+	return dumpKeyPairStr(privatekey, publickey)
+}
+
+// GenerateEd25519Key 生成Ed25519密钥对
+func GenerateEd25519Key() (ed25519.PrivateKey, ed25519.PublicKey, error) {
+	publickey, privatekey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privatekey, publickey, nil
+}
+
+// GenerateEd25519KeyStr 生成Ed25519密钥对，并将密钥转成字符串形式
+func GenerateEd25519KeyStr() (prikey, pubkey string, err error) {
+	privatekey, publickey, err := GenerateEd25519Key()
+	if err != nil {
+		return "", "", err
+	}
+	return dumpKeyPairStr(privatekey, publickey)
+}
+
+// dumpKeyPairStr 将密钥对转成字符串形式
+func dumpKeyPairStr(privatekey crypto.PrivateKey, publickey crypto.PublicKey) (prikey, pubkey string, err error) {
+	var pribuf []byte
+	if pribuf, err = DumpAnyPrivateKey(privatekey); err != nil {
+		return
+	}
+	var pubbuf []byte
+	if pubbuf, err = DumpAnyPublicKey(publickey); err != nil {
+		return
+	}
+	return string(pribuf), string(pubbuf), nil
+}
+
+// DumpAnyPublicKey 转存公钥，支持RSA、ECDSA、Ed25519
+func DumpAnyPublicKey(key crypto.PublicKey) ([]byte, error) {
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupport public key type: %T", key)
+	}
+	keybytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: keybytes,
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// DumpAnyPrivateKey 转存私钥，RSA为PKCS1格式，ECDSA为SEC1格式，Ed25519为PKCS8格式
+func DumpAnyPrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return DumpPrivateKey(k)
+	case *ecdsa.PrivateKey:
+		keybytes, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keybytes}), nil
+	case ed25519.PrivateKey:
+		keybytes, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keybytes}), nil
+	default:
+		return nil, fmt.Errorf("unsupport private key type: %T", key)
+	}
+}
+
+// LoadAnyPublicKey 获取公钥，返回*rsa.PublicKey、*ecdsa.PublicKey或ed25519.PublicKey
+func LoadAnyPublicKey(publickey []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(publickey)
+	if block == nil {
+		return nil, errors.New("get public key error")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return pub, nil
+	case *ecdsa.PublicKey:
+		if err = checkCurve(k.Curve); err != nil {
+			return nil, err
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupport public key type: %T", pub)
+	}
+}
+
+// LoadAnyPrivateKey 获取私钥，支持PKCS1、SEC1、PKCS8格式，
+// 返回*rsa.PrivateKey、*ecdsa.PrivateKey或ed25519.PrivateKey
+func LoadAnyPrivateKey(privateKey []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("get private key error")
+	}
+	if block.Type == encryptedPrivateKeyType {
+		return nil, ErrEncryptedPrivateKey
+	}
+	if pri, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return pri, nil
+	}
+	if pri, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		if err = checkCurve(pri.Curve); err != nil {
+			return nil, err
+		}
+		return pri, nil
+	}
+	pri, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k := pri.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if err = checkCurve(k.Curve); err != nil {
+			return nil, err
+		}
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupport private key type: %T", pri)
+	}
+}
+
+// SignECDSA 使用ECDSA算法签名，签名为ASN.1 DER编码后的base64
+func SignECDSA(key, src []byte, hash crypto.Hash) (string, error) {
+	signer, err := LoadAnyPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	prikey, ok := signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return "", errors.New("assert ecdsa private key error")
+	}
+	signByte, err := ecdsa.SignASN1(rand.Reader, prikey, hashSum(hash, src))
+	return base64.StdEncoding.EncodeToString(signByte), err
+}
+
+// VerifyECDSA 使用ECDSA验证签名
+func VerifyECDSA(key, src, sig []byte, hash crypto.Hash) error {
+	signBuf, err := base64.StdEncoding.DecodeString(string(sig))
+	if err != nil {
+		return err
+	}
+	pub, err := LoadAnyPublicKey(key)
+	if err != nil {
+		return err
+	}
+	pubkey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("assert ecdsa public key error")
+	}
+	if !ecdsa.VerifyASN1(pubkey, hashSum(hash, src), signBuf) {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// SignEd25519Key 使用Ed25519算法签名，签名为base64
+func SignEd25519Key(key, src []byte) (string, error) {
+	signer, err := LoadAnyPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	prikey, ok := signer.(ed25519.PrivateKey)
+	if !ok {
+		return "", errors.New("assert ed25519 private key error")
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(prikey, src)), nil
+}
+
+// VerifyEd25519Key 使用Ed25519验证签名
+func VerifyEd25519Key(key, src, sig []byte) error {
+	signBuf, err := base64.StdEncoding.DecodeString(string(sig))
+	if err != nil {
+		return err
+	}
+	pub, err := LoadAnyPublicKey(key)
+	if err != nil {
+		return err
+	}
+	pubkey, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("assert ed25519 public key error")
+	}
+	if !ed25519.Verify(pubkey, src, signBuf) {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// SignWithKey 根据私钥类型选择签名算法：RSA使用SHA256WithRSA，
+// ECDSA P-256使用SHA256WithECDSA，P-384使用SHA384WithECDSA，Ed25519使用Ed25519
+func SignWithKey(privateKey, data string) (string, error) {
+	signer, err := LoadAnyPrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", err
+	}
+	switch k := signer.(type) {
+	case *rsa.PrivateKey:
+		return SignSha256WithRsa(privateKey, data)
+	case *ecdsa.PrivateKey:
+		return SignECDSA([]byte(privateKey), []byte(data), ecdsaHash(k.Curve))
+	default:
+		return SignEd25519Key([]byte(privateKey), []byte(data))
+	}
+}
+
+// VerifyWithKey 根据公钥类型选择验签算法，与SignWithKey对应
+func VerifyWithKey(publicKey, source, targetSign string) error {
+	pub, err := LoadAnyPublicKey([]byte(publicKey))
+	if err != nil {
+		return err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return VerifySignSha256WithRsa(publicKey, source, targetSign)
+	case *ecdsa.PublicKey:
+		return VerifyECDSA([]byte(publicKey), []byte(source), []byte(targetSign), ecdsaHash(k.Curve))
+	default:
+		return VerifyEd25519Key([]byte(publicKey), []byte(source), []byte(targetSign))
+	}
+}
+
+// checkCurve 只支持P-256与P-384，其他曲线没有匹配的签名算法
+func checkCurve(curve elliptic.Curve) error {
+	if curve != elliptic.P256() && curve != elliptic.P384() {
+		return fmt.Errorf("unsupport curve: %v", curve.Params().Name)
+	}
+	return nil
+}
+
+// ecdsaHash 返回与曲线强度匹配的哈希算法，曲线已由checkCurve校验
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	if curve == elliptic.P384() {
+		return crypto.SHA384
+	}
+	return crypto.SHA256
+}
+
+// hashSum 计算src的摘要，hash为0时返回原文
+func hashSum(hash crypto.Hash, src []byte) []byte {
+	if hash == 0 {
+		return src
+	}
+	h := hash.New()
+	h.Write(src)
+	return h.Sum(nil)
+}
+
+// keySigner ECDSA、Ed25519签名，hash为0表示Ed25519
+type keySigner struct {
+	hash crypto.Hash
+}
+
+// Sign 计算签名
+func (s *keySigner) Sign(data string, params *SignParams) (string, error) {
+	if s.hash == 0 {
+		return SignEd25519Key([]byte(params.PrivateKey), []byte(data))
+	}
+	return SignECDSA([]byte(params.PrivateKey), []byte(data), s.hash)
+}
+
+// Verify 校验签名
+func (s *keySigner) Verify(data, sign string, params *SignParams) error {
+	if s.hash == 0 {
+		return VerifyEd25519Key([]byte(params.PublicKey), []byte(data), []byte(sign))
+	}
+	return VerifyECDSA([]byte(params.PublicKey), []byte(data), []byte(sign), s.hash)
+}
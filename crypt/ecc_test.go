@@ -0,0 +1,141 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateECDSAKeyStr(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		prikey, pubkey, err := GenerateECDSAKeyStr(curve)
+		assert.NoError(t, err)
+		assert.Contains(t, prikey, "EC PRIVATE KEY")
+		assert.Contains(t, pubkey, "PUBLIC KEY")
+
+		signer, err := LoadAnyPrivateKey([]byte(prikey))
+		assert.NoError(t, err)
+		assert.Equal(t, curve, signer.(*ecdsa.PrivateKey).Curve)
+		pub, err := LoadAnyPublicKey([]byte(pubkey))
+		assert.NoError(t, err)
+		assert.True(t, signer.Public().(*ecdsa.PublicKey).Equal(pub))
+	}
+
+	_, _, err := GenerateECDSAKeyStr(elliptic.P224())
+	assert.Error(t, err)
+}
+
+func TestLoadECDSAKeyCurve(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		assert.NoError(t, err)
+		pubbuf, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		assert.NoError(t, err)
+		_, err = LoadAnyPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubbuf}))
+		assert.Error(t, err)
+
+		sec1, err := x509.MarshalECPrivateKey(key)
+		assert.NoError(t, err)
+		_, err = LoadAnyPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+		assert.Error(t, err)
+
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+		assert.NoError(t, err)
+		_, err = LoadAnyPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+		assert.Error(t, err)
+	}
+}
+
+func TestGenerateEd25519KeyStr(t *testing.T) {
+	prikey, pubkey, err := GenerateEd25519KeyStr()
+	assert.NoError(t, err)
+	assert.Contains(t, prikey, "BEGIN PRIVATE KEY")
+	assert.Contains(t, pubkey, "PUBLIC KEY")
+
+	signer, err := LoadAnyPrivateKey([]byte(prikey))
+	assert.NoError(t, err)
+	pub, err := LoadAnyPublicKey([]byte(pubkey))
+	assert.NoError(t, err)
+	assert.True(t, signer.Public().(ed25519.PublicKey).Equal(pub))
+}
+
+func TestLoadAnyKey(t *testing.T) {
+	signer, err := LoadAnyPrivateKey([]byte(privateKey))
+	assert.NoError(t, err)
+	assert.IsType(t, &rsa.PrivateKey{}, signer)
+	pub, err := LoadAnyPublicKey([]byte(publicKey))
+	assert.NoError(t, err)
+	assert.IsType(t, &rsa.PublicKey{}, pub)
+
+	_, err = LoadAnyPrivateKey([]byte("not a key"))
+	assert.Error(t, err)
+	_, err = LoadAnyPublicKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestSignECDSA(t *testing.T) {
+	prikey, pubkey, err := GenerateECDSAKeyStr(elliptic.P384())
+	assert.NoError(t, err)
+
+	sign, err := SignECDSA([]byte(prikey), []byte("hello"), crypto.SHA384)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyECDSA([]byte(pubkey), []byte("hello"), []byte(sign), crypto.SHA384))
+	assert.ErrorIs(t, VerifyECDSA([]byte(pubkey), []byte("hello?"), []byte(sign), crypto.SHA384), ErrSignMismatch)
+
+	// 使用RSA私钥
+	_, err = SignECDSA([]byte(privateKey), []byte("hello"), crypto.SHA256)
+	assert.Error(t, err)
+}
+
+func TestSignEd25519Key(t *testing.T) {
+	prikey, pubkey, err := GenerateEd25519KeyStr()
+	assert.NoError(t, err)
+
+	sign, err := SignEd25519Key([]byte(prikey), []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyEd25519Key([]byte(pubkey), []byte("hello"), []byte(sign)))
+	assert.ErrorIs(t, VerifyEd25519Key([]byte(pubkey), []byte("hello?"), []byte(sign)), ErrSignMismatch)
+}
+
+func TestSignWithKey(t *testing.T) {
+	ecPrikey, ecPubkey, _ := GenerateECDSAKeyStr(elliptic.P256())
+	edPrikey, edPubkey, _ := GenerateEd25519KeyStr()
+	keys := [][2]string{{privateKey, publicKey}, {ecPrikey, ecPubkey}, {edPrikey, edPubkey}}
+
+	for _, key := range keys {
+		sign, err := SignWithKey(key[0], "hello")
+		assert.NoError(t, err)
+		assert.NoError(t, VerifyWithKey(key[1], "hello", sign))
+		assert.Error(t, VerifyWithKey(key[1], "hello?", sign))
+	}
+
+	// RSA签名与SignSha256WithRsa兼容
+	sign, err := SignSha256WithRsa(privateKey, "hello")
+	assert.NoError(t, err)
+	assert.NoError(t, VerifyWithKey(publicKey, "hello", sign))
+}
+
+func TestCalcSignECC(t *testing.T) {
+	ecPrikey, ecPubkey, _ := GenerateECDSAKeyStr(elliptic.P256())
+	ec384Prikey, ec384Pubkey, _ := GenerateECDSAKeyStr(elliptic.P384())
+	edPrikey, edPubkey, _ := GenerateEd25519KeyStr()
+	tests := []*SignParams{
+		{SignType: SignSHA256WithECDSA, PrivateKey: ecPrikey, PublicKey: ecPubkey},
+		{SignType: SignSHA384WithECDSA, PrivateKey: ec384Prikey, PublicKey: ec384Pubkey, SafeBase64: true},
+		{SignType: SignEd25519, PrivateKey: edPrikey, PublicKey: edPubkey},
+	}
+	for _, params := range tests {
+		sign, err := CalcSign("Hello World!", params)
+		assert.NoError(t, err)
+		assert.NoError(t, VerifySign("Hello World!", sign, params))
+		assert.ErrorIs(t, VerifySign("Hello World?", sign, params), ErrSignMismatch)
+	}
+}
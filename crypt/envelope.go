@@ -0,0 +1,202 @@
+package crypt
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 信封加密格式：
+// version(1字节) | keyType(1字节) | wrappedKeyLen(2字节，大端) | wrappedKey | body
+// body为AESEncryptGCM输出的GCM信封，数据密钥为随机生成的32字节AES-256密钥，
+// body的附加认证数据为 version | keyType | wrappedKeyLen | wrappedKey | 调用方的附加数据。
+// wrappedKey根据keyType不同：
+// EnvelopeRSAOAEP: RSA-OAEP(SHA-256)加密后的数据密钥
+// EnvelopeECDHP256/EnvelopeECDHP384: 临时公钥(未压缩格式) | AES-GCM(KEK, 数据密钥)，
+// 其中 KEK = SHA-256(ECDH共享密钥 | 临时公钥)
+const (
+	// EnvelopeVersion1 当前的信封加密格式版本号
+	EnvelopeVersion1 byte = 0x01
+
+	// EnvelopeRSAOAEP 使用RSA-OAEP包装数据密钥
+	EnvelopeRSAOAEP byte = 0x01
+	// EnvelopeECDHP256 使用P-256 ECDH包装数据密钥
+	EnvelopeECDHP256 byte = 0x02
+	// EnvelopeECDHP384 使用P-384 ECDH包装数据密钥
+	EnvelopeECDHP384 byte = 0x03
+
+	envelopeDataKeySize   = 32
+	envelopeHeaderSize    = 4
+	envelopeWrapKeyDomain = "saas/crypt envelope"
+)
+
+var (
+	// ErrEnvelope 信封格式错误
+	ErrEnvelope = errors.New("envelope invalid")
+	// ErrEnvelopeKeyType 公私钥类型与信封不匹配
+	ErrEnvelopeKeyType = errors.New("envelope key type mismatch")
+)
+
+// EnvelopeEncrypt 信封加密：随机生成AES数据密钥加密明文，再用publicKey包装数据密钥。
+// publicKey为PEM格式的RSA或ECDSA(P-256/P-384)公钥，明文长度不受公钥长度限制
+func EnvelopeEncrypt(publicKey, plaintext, additionalData []byte) ([]byte, error) {
+	pub, err := LoadAnyPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, envelopeDataKeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	var keyType byte
+	var wrappedKey []byte
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		keyType = EnvelopeRSAOAEP
+		wrappedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, k, dataKey, []byte(envelopeWrapKeyDomain))
+	case *ecdsa.PublicKey:
+		var ecdhKey *ecdh.PublicKey
+		if ecdhKey, err = k.ECDH(); err != nil {
+			return nil, err
+		}
+		if keyType, err = envelopeECDHKeyType(ecdhKey.Curve()); err != nil {
+			return nil, err
+		}
+		wrappedKey, err = wrapKeyECDH(ecdhKey, dataKey)
+	default:
+		return nil, fmt.Errorf("unsupport envelope public key type: %T", pub)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) > 0xffff {
+		return nil, ErrEnvelope
+	}
+
+	header := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(wrappedKey))
+	header[0] = EnvelopeVersion1
+	header[1] = keyType
+	binary.BigEndian.PutUint16(header[2:], uint16(len(wrappedKey)))
+	header = append(header, wrappedKey...)
+
+	body, err := AESEncryptGCM(dataKey, plaintext, envelopeAdditionalData(header, additionalData))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
+
+// EnvelopeDecrypt 信封解密：用privateKey解开数据密钥，再解密明文。
+// privateKey为PEM格式的RSA或ECDSA私钥，additionalData需与加密时一致
+func EnvelopeDecrypt(privateKey, envelope, additionalData []byte) ([]byte, error) {
+	signer, err := LoadAnyPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < envelopeHeaderSize {
+		return nil, ErrEnvelope
+	}
+	if envelope[0] != EnvelopeVersion1 {
+		return nil, ErrGCMVersion
+	}
+	keyType := envelope[1]
+	wrappedKeyLen := int(binary.BigEndian.Uint16(envelope[2:envelopeHeaderSize]))
+	if len(envelope) < envelopeHeaderSize+wrappedKeyLen {
+		return nil, ErrEnvelope
+	}
+	header := envelope[:envelopeHeaderSize+wrappedKeyLen]
+	wrappedKey := header[envelopeHeaderSize:]
+
+	var dataKey []byte
+	switch k := signer.(type) {
+	case *rsa.PrivateKey:
+		if keyType != EnvelopeRSAOAEP {
+			return nil, ErrEnvelopeKeyType
+		}
+		dataKey, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, k, wrappedKey, []byte(envelopeWrapKeyDomain))
+	case *ecdsa.PrivateKey:
+		var ecdhKey *ecdh.PrivateKey
+		if ecdhKey, err = k.ECDH(); err != nil {
+			return nil, err
+		}
+		if expect, cerr := envelopeECDHKeyType(ecdhKey.Curve()); cerr != nil || expect != keyType {
+			return nil, ErrEnvelopeKeyType
+		}
+		dataKey, err = unwrapKeyECDH(ecdhKey, wrappedKey)
+	default:
+		return nil, ErrEnvelopeKeyType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AESDecryptGCM(dataKey, envelope[len(header):], envelopeAdditionalData(header, additionalData))
+}
+
+// envelopeECDHKeyType 根据曲线返回信封中的keyType
+func envelopeECDHKeyType(curve ecdh.Curve) (byte, error) {
+	switch curve {
+	case ecdh.P256():
+		return EnvelopeECDHP256, nil
+	case ecdh.P384():
+		return EnvelopeECDHP384, nil
+	default:
+		return 0, fmt.Errorf("unsupport envelope curve: %v", curve)
+	}
+}
+
+// wrapKeyECDH 生成临时密钥对，与接收方公钥协商出KEK后包装数据密钥
+func wrapKeyECDH(pub *ecdh.PublicKey, dataKey []byte) ([]byte, error) {
+	ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	wrapped, err := AESEncryptGCM(envelopeKEK(shared, ephemeralPub), dataKey, []byte(envelopeWrapKeyDomain))
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralPub, wrapped...), nil
+}
+
+// unwrapKeyECDH 用接收方私钥与临时公钥协商出KEK后解开数据密钥
+func unwrapKeyECDH(pri *ecdh.PrivateKey, wrappedKey []byte) ([]byte, error) {
+	pointSize := len(pri.PublicKey().Bytes())
+	if len(wrappedKey) < pointSize {
+		return nil, ErrEnvelope
+	}
+	ephemeralPub := wrappedKey[:pointSize]
+	pub, err := pri.Curve().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, ErrEnvelope
+	}
+	shared, err := pri.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	return AESDecryptGCM(envelopeKEK(shared, ephemeralPub), wrappedKey[pointSize:], []byte(envelopeWrapKeyDomain))
+}
+
+// envelopeKEK 根据ECDH共享密钥与临时公钥计算KEK
+func envelopeKEK(shared, ephemeralPub []byte) []byte {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeralPub)
+	return h.Sum(nil)
+}
+
+// envelopeAdditionalData 将信封头与调用方的附加数据拼接成body的附加认证数据
+func envelopeAdditionalData(header, additionalData []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(additionalData))
+	ad = append(ad, header...)
+	return append(ad, additionalData...)
+}
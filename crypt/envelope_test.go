@@ -0,0 +1,65 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvelopeEncrypt(t *testing.T) {
+	ecPrikey, ecPubkey, _ := GenerateECDSAKeyStr(elliptic.P256())
+	ec384Prikey, ec384Pubkey, _ := GenerateECDSAKeyStr(elliptic.P384())
+	keys := []struct {
+		prikey, pubkey string
+		keyType        byte
+	}{
+		{privateKey, publicKey, EnvelopeRSAOAEP},
+		{ecPrikey, ecPubkey, EnvelopeECDHP256},
+		{ec384Prikey, ec384Pubkey, EnvelopeECDHP384},
+	}
+	// 明文远大于RSA模长
+	plain := bytes.Repeat([]byte("test crypt"), 1000)
+	ad := []byte("merchant:Tencent")
+
+	for _, key := range keys {
+		envelope, err := EnvelopeEncrypt([]byte(key.pubkey), plain, ad)
+		assert.NoError(t, err)
+		assert.Equal(t, EnvelopeVersion1, envelope[0])
+		assert.Equal(t, key.keyType, envelope[1])
+
+		result, err := EnvelopeDecrypt([]byte(key.prikey), envelope, ad)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, result, "should equal")
+
+		// 附加数据不一致
+		_, err = EnvelopeDecrypt([]byte(key.prikey), envelope, nil)
+		assert.ErrorIs(t, err, ErrGCMAuth)
+
+		// 篡改包装后的数据密钥
+		tampered := append([]byte{}, envelope...)
+		tampered[envelopeHeaderSize+1] ^= 0x01
+		_, err = EnvelopeDecrypt([]byte(key.prikey), tampered, ad)
+		assert.Error(t, err)
+
+		// 信封过短
+		_, err = EnvelopeDecrypt([]byte(key.prikey), envelope[:10], ad)
+		assert.ErrorIs(t, err, ErrEnvelope)
+	}
+
+	// 私钥类型与信封不匹配
+	envelope, err := EnvelopeEncrypt([]byte(publicKey), plain, ad)
+	assert.NoError(t, err)
+	_, err = EnvelopeDecrypt([]byte(ecPrikey), envelope, ad)
+	assert.ErrorIs(t, err, ErrEnvelopeKeyType)
+	envelope, err = EnvelopeEncrypt([]byte(ecPubkey), plain, ad)
+	assert.NoError(t, err)
+	_, err = EnvelopeDecrypt([]byte(ec384Prikey), envelope, ad)
+	assert.ErrorIs(t, err, ErrEnvelopeKeyType)
+
+	// Ed25519公钥不支持信封加密
+	_, edPubkey, _ := GenerateEd25519KeyStr()
+	_, err = EnvelopeEncrypt([]byte(edPubkey), plain, ad)
+	assert.Error(t, err)
+}
@@ -0,0 +1,266 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrJWK JWK格式错误
+var ErrJWK = errors.New("jwk invalid")
+
+// JWK 定义JSON Web Key(RFC 7517)中的公钥字段，只支持RSA、EC、OKP(Ed25519)公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC、OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS 定义JSON Web Key Set
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// NewJWK 根据公钥生成JWK，kid为空时使用RFC 7638指纹
+func NewJWK(key crypto.PublicKey) (*JWK, error) {
+	var jwk *JWK
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk = &JWK{
+			Kty: "RSA",
+			N:   b64URL(k.N.Bytes()),
+			E:   b64URL(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		crv, size, err := jwkCurve(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		jwk = &JWK{
+			Kty: "EC",
+			Crv: crv,
+			X:   b64URL(k.X.FillBytes(make([]byte, size))),
+			Y:   b64URL(k.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		jwk = &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64URL(k),
+		}
+	default:
+		return nil, fmt.Errorf("unsupport public key type: %T", key)
+	}
+	kid, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = kid
+	return jwk, nil
+}
+
+// PEMToJWK 将PEM格式的公钥转成JWK
+func PEMToJWK(publicKey []byte) (*JWK, error) {
+	pub, err := LoadAnyPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewJWK(pub)
+}
+
+// JWKToPEM 将JWK转成PEM格式的公钥
+func JWKToPEM(jwk *JWK) ([]byte, error) {
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return DumpAnyPublicKey(pub)
+}
+
+// PublicKey 将JWK转成公钥，返回*rsa.PublicKey、*ecdsa.PublicKey或ed25519.PublicKey
+func (j *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := b64URLDecode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64URLDecode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		eInt := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !eInt.IsInt64() || eInt.Int64() < 3 || eInt.Int64() > 1<<31-1 {
+			return nil, ErrJWK
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(eInt.Int64())}, nil
+	case "EC":
+		curve, size, err := jwkCurveByName(j.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := b64URLDecode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64URLDecode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != size || len(y) != size {
+			return nil, ErrJWK
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// 通过ECDH转换校验点是否在曲线上
+		if _, err = pub.ECDH(); err != nil {
+			return nil, ErrJWK
+		}
+		return pub, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupport jwk curve: %v", j.Crv)
+		}
+		x, err := b64URLDecode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrJWK
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupport jwk kty: %v", j.Kty)
+	}
+}
+
+// Thumbprint 计算RFC 7638规定的SHA-256指纹，结果为base64url，可以作为稳定的kid
+func (j *JWK) Thumbprint() (string, error) {
+	// 按RFC 7638，只包含必需字段，字段按字典序排列，不含空白
+	var members interface{}
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Crv, j.Kty, j.X, j.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		return "", fmt.Errorf("unsupport jwk kty: %v", j.Kty)
+	}
+	buf, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return b64URL(sum[:]), nil
+}
+
+// NewJWKS 生成JWKS实例
+func NewJWKS() *JWKS {
+	return &JWKS{Keys: []*JWK{}}
+}
+
+// AddPEM 将PEM格式的公钥加入JWKS，use和alg为可选字段，返回公钥的kid
+func (s *JWKS) AddPEM(publicKey []byte, use, alg string) (string, error) {
+	jwk, err := PEMToJWK(publicKey)
+	if err != nil {
+		return "", err
+	}
+	jwk.Use, jwk.Alg = use, alg
+	if s.Get(jwk.Kid) != nil {
+		return "", fmt.Errorf("this jwk already exist:%s", jwk.Kid)
+	}
+	s.Keys = append(s.Keys, jwk)
+	return jwk.Kid, nil
+}
+
+// Get 根据kid获取JWK，不存在则返回nil
+func (s *JWKS) Get(kid string) *JWK {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// Marshal 输出JWKS文档，可以直接作为jwks_uri的响应
+func (s *JWKS) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// ParseJWKS 解析JWKS文档
+func ParseJWKS(data []byte) (*JWKS, error) {
+	s := NewJWKS()
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// jwkCurve 返回曲线在JWK中的名字与坐标长度
+func jwkCurve(curve elliptic.Curve) (string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", 32, nil
+	case elliptic.P384():
+		return "P-384", 48, nil
+	default:
+		return "", 0, fmt.Errorf("unsupport curve: %v", curve.Params().Name)
+	}
+}
+
+// jwkCurveByName 根据JWK中的曲线名返回曲线与坐标长度
+func jwkCurveByName(crv string) (elliptic.Curve, int, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), 32, nil
+	case "P-384":
+		return elliptic.P384(), 48, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupport jwk curve: %v", crv)
+	}
+}
+
+// b64URL base64url编码，不带填充
+func b64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// b64URLDecode base64url解码，不带填充
+func b64URLDecode(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrJWK
+	}
+	return b, nil
+}
@@ -0,0 +1,102 @@
+package crypt
+
+import (
+	"crypto/elliptic"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 3.1节的示例
+	jwk := &JWK{
+		Kty: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+			"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91" +
+			"CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	thumbprint, err := jwk.Thumbprint()
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
+
+func TestPEMToJWK(t *testing.T) {
+	_, ecPubkey, _ := GenerateECDSAKeyStr(elliptic.P256())
+	_, ec384Pubkey, _ := GenerateECDSAKeyStr(elliptic.P384())
+	_, edPubkey, _ := GenerateEd25519KeyStr()
+
+	tests := []struct {
+		name   string
+		pubkey string
+		kty    string
+	}{
+		{"RSA", publicKey, "RSA"},
+		{"P-256", ecPubkey, "EC"},
+		{"P-384", ec384Pubkey, "EC"},
+		{"Ed25519", edPubkey, "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwk, err := PEMToJWK([]byte(tt.pubkey))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.kty, jwk.Kty)
+			assert.NotEmpty(t, jwk.Kid)
+
+			pem, err := JWKToPEM(jwk)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.TrimSpace(tt.pubkey), strings.TrimSpace(string(pem)), "should equal")
+
+			// 指纹是稳定的
+			jwk2, err := PEMToJWK(pem)
+			assert.NoError(t, err)
+			assert.Equal(t, jwk.Kid, jwk2.Kid)
+		})
+	}
+}
+
+func TestJWKInvalid(t *testing.T) {
+	_, err := (&JWK{Kty: "oct"}).PublicKey()
+	assert.Error(t, err)
+	_, err = (&JWK{Kty: "EC", Crv: "P-521"}).PublicKey()
+	assert.Error(t, err)
+	_, err = (&JWK{Kty: "OKP", Crv: "Ed25519", X: "AQAB"}).PublicKey()
+	assert.ErrorIs(t, err, ErrJWK)
+	_, err = (&JWK{Kty: "RSA", N: "!!", E: "AQAB"}).PublicKey()
+	assert.ErrorIs(t, err, ErrJWK)
+
+	// 不在曲线上的点
+	_, ecPubkey, _ := GenerateECDSAKeyStr(elliptic.P256())
+	jwk, err := PEMToJWK([]byte(ecPubkey))
+	assert.NoError(t, err)
+	jwk.Y = jwk.X
+	_, err = jwk.PublicKey()
+	assert.ErrorIs(t, err, ErrJWK)
+}
+
+func TestJWKS(t *testing.T) {
+	_, edPubkey, _ := GenerateEd25519KeyStr()
+	jwks := NewJWKS()
+	kid1, err := jwks.AddPEM([]byte(publicKey), "sig", "RS256")
+	assert.NoError(t, err)
+	kid2, err := jwks.AddPEM([]byte(edPubkey), "sig", "EdDSA")
+	assert.NoError(t, err)
+	_, err = jwks.AddPEM([]byte(publicKey), "sig", "RS256")
+	assert.Error(t, err)
+
+	doc, err := jwks.Marshal()
+	assert.NoError(t, err)
+	t.Logf("%s", doc)
+
+	jwks2, err := ParseJWKS(doc)
+	assert.NoError(t, err)
+	assert.Len(t, jwks2.Keys, 2)
+	assert.Equal(t, "RS256", jwks2.Get(kid1).Alg)
+	pem, err := JWKToPEM(jwks2.Get(kid2))
+	assert.NoError(t, err)
+	assert.Equal(t, edPubkey, string(pem))
+	assert.Nil(t, jwks2.Get("not exist"))
+}
@@ -0,0 +1,149 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// JWS签名算法(RFC 7518)
+const (
+	JWSRS256 = "RS256"
+	JWSES256 = "ES256"
+	JWSES384 = "ES384"
+	JWSEdDSA = "EdDSA"
+)
+
+// ErrJWS JWS格式错误
+var ErrJWS = errors.New("jws invalid")
+
+// JWSHeader JWS头部
+type JWSHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// JWSAlgorithm 根据公钥类型返回JWS算法：RSA为RS256，ECDSA P-256为ES256、P-384为ES384，Ed25519为EdDSA
+func JWSAlgorithm(key crypto.PublicKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWSRS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return JWSES256, nil
+		case elliptic.P384():
+			return JWSES384, nil
+		}
+		return "", fmt.Errorf("unsupport curve: %v", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return JWSEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupport public key type: %T", key)
+	}
+}
+
+// SignJWS 生成compact格式的JWS，typ为JWT，算法由私钥类型决定，见JWSAlgorithm
+func SignJWS(key crypto.Signer, kid string, payload []byte) (string, error) {
+	alg, err := JWSAlgorithm(key.Public())
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(&JWSHeader{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64URL(header) + "." + b64URL(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hashSum(crypto.SHA256, []byte(signingInput)))
+	case *ecdsa.PrivateKey:
+		// JWS中ECDSA签名为定长的r||s，而不是ASN.1 DER
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hashSum(ecdsaHash(k.Curve), []byte(signingInput)))
+		if err == nil {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signingInput))
+	default:
+		return "", fmt.Errorf("unsupport private key type: %T", key)
+	}
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64URL(sig), nil
+}
+
+// ParseJWSHeader 解析JWS头部但不验签，可用于按kid选择公钥
+func ParseJWSHeader(token string) (*JWSHeader, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWS
+	}
+	buf, err := b64URLDecode(parts[0])
+	if err != nil {
+		return nil, ErrJWS
+	}
+	header := &JWSHeader{}
+	if err = json.Unmarshal(buf, header); err != nil {
+		return nil, ErrJWS
+	}
+	return header, nil
+}
+
+// VerifyJWS 使用公钥校验compact格式的JWS并返回头部与payload。
+// 头部中的alg必须与公钥类型对应的算法一致，避免算法混淆攻击
+func VerifyJWS(key crypto.PublicKey, token string) (*JWSHeader, []byte, error) {
+	header, err := ParseJWSHeader(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	alg, err := JWSAlgorithm(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Alg != alg {
+		return nil, nil, fmt.Errorf("jws alg mismatch: %s", header.Alg)
+	}
+	idx := strings.LastIndexByte(token, '.')
+	signingInput := token[:idx]
+	sig, err := b64URLDecode(token[idx+1:])
+	if err != nil {
+		return nil, nil, ErrJWS
+	}
+
+	var ok bool
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, hashSum(crypto.SHA256, []byte(signingInput)), sig) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			ok = ecdsa.Verify(k, hashSum(ecdsaHash(k.Curve), []byte(signingInput)), r, s)
+		}
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, []byte(signingInput), sig)
+	}
+	if !ok {
+		return nil, nil, ErrSignMismatch
+	}
+	payload, err := b64URLDecode(signingInput[strings.IndexByte(signingInput, '.')+1:])
+	if err != nil {
+		return nil, nil, ErrJWS
+	}
+	return header, payload, nil
+}
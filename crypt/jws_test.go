@@ -0,0 +1,68 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignJWS(t *testing.T) {
+	rsaKey, err := LoadAnyPrivateKey([]byte(privateKey))
+	assert.NoError(t, err)
+	ecKey, _, err := GenerateECDSAKey(elliptic.P256())
+	assert.NoError(t, err)
+	ec384Key, _, err := GenerateECDSAKey(elliptic.P384())
+	assert.NoError(t, err)
+	edKey, _, err := GenerateEd25519Key()
+	assert.NoError(t, err)
+
+	payload := []byte(`{"sub":"Tencent"}`)
+	for alg, key := range map[string]crypto.Signer{
+		JWSRS256: rsaKey, JWSES256: ecKey, JWSES384: ec384Key, JWSEdDSA: edKey,
+	} {
+		token, err := SignJWS(key, "kid1", payload)
+		assert.NoError(t, err)
+		header, got, err := VerifyJWS(key.Public(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, &JWSHeader{Alg: alg, Typ: "JWT", Kid: "kid1"}, header)
+		assert.Equal(t, payload, got)
+
+		// 篡改payload
+		parts := strings.Split(token, ".")
+		parts[1] = b64URL([]byte(`{"sub":"Other"}`))
+		_, _, err = VerifyJWS(key.Public(), strings.Join(parts, "."))
+		assert.Equal(t, ErrSignMismatch, err)
+	}
+
+	// 公钥类型与alg不一致
+	token, err := SignJWS(edKey, "", payload)
+	assert.NoError(t, err)
+	_, _, err = VerifyJWS(ecKey.Public(), token)
+	assert.Error(t, err)
+	_, _, err = VerifyJWS(edKey.Public(), "a.b")
+	assert.Equal(t, ErrJWS, err)
+}
+
+func TestVerifyJWSVector(t *testing.T) {
+	// RFC 8037 A.4
+	seed, err := b64URLDecode("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	assert.NoError(t, err)
+	key := ed25519.NewKeyFromSeed(seed)
+	token := "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc." +
+		"hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+	header, payload, err := VerifyJWS(key.Public(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, JWSEdDSA, header.Alg)
+	assert.Equal(t, "Example of Ed25519 signing", string(payload))
+
+	_, err = JWSAlgorithm(&ecdsa.PublicKey{Curve: elliptic.P224()})
+	assert.Error(t, err)
+	_, err = JWSAlgorithm(&rsa.PrivateKey{})
+	assert.Error(t, err)
+}
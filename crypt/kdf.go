@@ -0,0 +1,100 @@
+package crypt
+
+import (
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// 常量定义
+const (
+	// DerivedKeySize 派生子密钥的默认长度，可直接用作AES-256密钥
+	DerivedKeySize = 32
+
+	// 派生子密钥的层级标签
+	deriveTenant   = "tenant"
+	deriveMerchant = "merchant"
+	deriveApp      = "app"
+
+	kdfInfoPrefix = "saas/crypt kdf v1"
+)
+
+// ErrMasterKey 主密钥长度不足
+var ErrMasterKey = errors.New("master key too short")
+
+// HKDF 按RFC 5869派生长度为length的密钥，hash支持crypto.SHA256、crypto.SHA512
+func HKDF(hash crypto.Hash, secret, salt, info []byte, length int) ([]byte, error) {
+	if hash != crypto.SHA256 && hash != crypto.SHA512 {
+		return nil, fmt.Errorf("unsupport hkdf hash: %v", hash)
+	}
+	if length <= 0 || length > 255*hash.Size() {
+		return nil, fmt.Errorf("hkdf length invalid: %d", length)
+	}
+	key := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(hash.New, secret, salt, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// KeyDeriver 从单个主密钥确定性地派生按租户、商户、App隔离的子密钥，
+// 子密钥无需存储，相同的主密钥、层级与标签总是得到相同的子密钥
+type KeyDeriver struct {
+	masterKey []byte
+	hash      crypto.Hash
+}
+
+// NewKeyDeriver 生成KeyDeriver实例，主密钥至少16字节，hash支持crypto.SHA256、crypto.SHA512
+func NewKeyDeriver(masterKey []byte, hash crypto.Hash) (*KeyDeriver, error) {
+	if len(masterKey) < 16 {
+		return nil, ErrMasterKey
+	}
+	if hash != crypto.SHA256 && hash != crypto.SHA512 {
+		return nil, fmt.Errorf("unsupport hkdf hash: %v", hash)
+	}
+	return &KeyDeriver{masterKey: append([]byte(nil), masterKey...), hash: hash}, nil
+}
+
+// Derive 派生子密钥。label表示用途，如"data-encrypt"；path为层级，如租户ID、商户ID。
+// info中每个字段都带长度前缀，不同的字段划分不会得到相同的info
+func (d *KeyDeriver) Derive(label string, length int, path ...string) ([]byte, error) {
+	info, err := appendKDFField([]byte(kdfInfoPrefix), label)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range path {
+		if info, err = appendKDFField(info, p); err != nil {
+			return nil, err
+		}
+	}
+	return HKDF(d.hash, d.masterKey, nil, info, length)
+}
+
+// TenantKey 派生租户级子密钥
+func (d *KeyDeriver) TenantKey(tenantID, label string) ([]byte, error) {
+	return d.Derive(label, DerivedKeySize, deriveTenant, tenantID)
+}
+
+// MerchantKey 派生租户下的商户级子密钥
+func (d *KeyDeriver) MerchantKey(tenantID, merchantID, label string) ([]byte, error) {
+	return d.Derive(label, DerivedKeySize, deriveTenant, tenantID, deriveMerchant, merchantID)
+}
+
+// AppKey 派生商户下的App级子密钥
+func (d *KeyDeriver) AppKey(tenantID, merchantID, appID, label string) ([]byte, error) {
+	return d.Derive(label, DerivedKeySize, deriveTenant, tenantID, deriveMerchant, merchantID, deriveApp, appID)
+}
+
+// appendKDFField 追加2字节长度前缀与字段内容，字段超过65535字节时返回错误
+func appendKDFField(info []byte, field string) ([]byte, error) {
+	if len(field) > math.MaxUint16 {
+		return nil, fmt.Errorf("kdf field too long: %d", len(field))
+	}
+	info = binary.BigEndian.AppendUint16(info, uint16(len(field)))
+	return append(info, field...), nil
+}
@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869 A.1
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	okm, err := HKDF(crypto.SHA256, ikm, salt, info, 42)
+	assert.NoError(t, err)
+	assert.Equal(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		hex.EncodeToString(okm))
+
+	okm, err = HKDF(crypto.SHA512, ikm, salt, info, 64)
+	assert.NoError(t, err)
+	assert.Len(t, okm, 64)
+
+	_, err = HKDF(crypto.SHA1, ikm, salt, info, 32)
+	assert.Error(t, err)
+	_, err = HKDF(crypto.SHA256, ikm, salt, info, 255*32+1)
+	assert.Error(t, err)
+}
+
+func TestKeyDeriver(t *testing.T) {
+	_, err := NewKeyDeriver([]byte("short"), crypto.SHA256)
+	assert.Equal(t, ErrMasterKey, err)
+
+	masterKey := bytes.Repeat([]byte{0x01}, 32)
+	d, err := NewKeyDeriver(masterKey, crypto.SHA256)
+	assert.NoError(t, err)
+
+	k1, err := d.TenantKey("t1", "data")
+	assert.NoError(t, err)
+	assert.Len(t, k1, DerivedKeySize)
+	k2, err := d.TenantKey("t1", "data")
+	assert.NoError(t, err)
+	assert.Equal(t, k1, k2)
+
+	keys := map[string]bool{hex.EncodeToString(k1): true}
+	for _, f := range []func() ([]byte, error){
+		func() ([]byte, error) { return d.TenantKey("t2", "data") },
+		func() ([]byte, error) { return d.TenantKey("t1", "index") },
+		func() ([]byte, error) { return d.MerchantKey("t1", "m1", "data") },
+		func() ([]byte, error) { return d.AppKey("t1", "m1", "a1", "data") },
+		func() ([]byte, error) { return d.AppKey("t1", "m1a", "1", "data") },
+	} {
+		k, err := f()
+		assert.NoError(t, err)
+		assert.False(t, keys[hex.EncodeToString(k)])
+		keys[hex.EncodeToString(k)] = true
+	}
+
+	// 派生出的子密钥可以直接用于AES-GCM
+	ciphertext, err := AESEncryptGCM(k1, []byte("tenant data"), nil)
+	assert.NoError(t, err)
+	plaintext, err := AESDecryptGCM(k2, ciphertext, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("tenant data"), plaintext)
+
+	d512, err := NewKeyDeriver(masterKey, crypto.SHA512)
+	assert.NoError(t, err)
+	k3, err := d512.TenantKey("t1", "data")
+	assert.NoError(t, err)
+	assert.NotEqual(t, k1, k3)
+
+	// 字段超过2字节长度前缀的范围时返回错误，不截断
+	long := strings.Repeat("a", math.MaxUint16+1)
+	_, err = d.Derive(long, DerivedKeySize)
+	assert.Error(t, err)
+	_, err = d.TenantKey(long, "data")
+	assert.Error(t, err)
+	_, err = d.Derive(strings.Repeat("a", math.MaxUint16), DerivedKeySize)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,487 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 常量定义
+const (
+	// 密钥用途
+	KeyPurposeEncrypt = "encrypt"
+	KeyPurposeSign    = "sign"
+
+	// 密钥版本状态
+	KeyStatePrimary = "primary" // 主版本，用于加密、签名
+	KeyStateActive  = "active"  // 只用于解密、验签
+	KeyStateRetired = "retired" // 已退役，不再可用
+
+	keyStoreAdditionalData = "saas/crypt keystore"
+	keyHMACInfo            = "saas/crypt keymanager hmac"
+)
+
+var (
+	// ErrKeyNotFound 密钥或密钥版本不存在
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyRetired 密钥版本已退役
+	ErrKeyRetired = errors.New("key retired")
+	// ErrKeyPurpose 密钥用途不匹配
+	ErrKeyPurpose = errors.New("key purpose mismatch")
+	// ErrKeyID 密文或签名中的密钥ID格式错误
+	ErrKeyID = errors.New("key id invalid")
+)
+
+// KeyManager 密钥管理接口。
+// 密钥以name区分，每个name下有多个版本，密钥ID的格式为 name/v版本号。
+// 加密输出与签名都带有密钥ID，解密与验签时自动选择对应的版本，因此密钥轮换后旧数据仍然可用
+type KeyManager interface {
+	// CreateKey 创建密钥，返回第一个版本的密钥ID
+	CreateKey(name, purpose string) (string, error)
+	// Rotate 生成新版本并设为主版本，原主版本降为active，返回新的密钥ID
+	Rotate(name string) (string, error)
+	// Retire 将一个非主版本退役，退役后不能再用于解密、验签
+	Retire(keyID string) error
+	// Encrypt 用主版本加密，输出带密钥ID
+	Encrypt(name string, plaintext, additionalData []byte) ([]byte, error)
+	// Decrypt 根据密文中的密钥ID选择版本解密
+	Decrypt(ciphertext, additionalData []byte) ([]byte, error)
+	// Sign 用主版本签名，输出格式为 密钥ID.base64签名
+	Sign(name string, data []byte) (string, error)
+	// Verify 根据签名中的密钥ID选择版本验签
+	Verify(data []byte, sign string) error
+	// PublicKey 获取签名密钥版本对应的PEM公钥
+	PublicKey(keyID string) (string, error)
+	// Keys 返回所有密钥的名字，按字典序排列
+	Keys() []string
+	// Versions 返回密钥未退役的版本ID，主版本在前，其余按版本号从新到旧
+	Versions(name string) ([]string, error)
+	// HMAC 用加密密钥的指定版本派生的MAC密钥计算HMAC-SHA256，用于Token哈希等确定性摘要
+	HMAC(keyID string, data []byte) ([]byte, error)
+	// Signer 返回签名密钥指定版本的私钥，用于JWS等需要标准签名格式的场景
+	Signer(keyID string) (crypto.Signer, error)
+	// VerifyKey 返回签名密钥指定版本的公钥，已退役的版本返回ErrKeyRetired
+	VerifyKey(keyID string) (crypto.PublicKey, error)
+}
+
+// KeyVersion 密钥的一个版本
+type KeyVersion struct {
+	Version   int       `json:"version"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	Material  []byte    `json:"material"`            // 加密密钥为AES-256密钥，签名密钥为PEM私钥
+	PublicKey string    `json:"publickey,omitempty"` // 签名密钥的PEM公钥
+}
+
+// ManagedKey 一个密钥的所有版本
+type ManagedKey struct {
+	Name     string        `json:"name"`
+	Purpose  string        `json:"purpose"`
+	Versions []*KeyVersion `json:"versions"`
+}
+
+// primary 返回主版本
+func (k *ManagedKey) primary() *KeyVersion {
+	for _, v := range k.Versions {
+		if v.State == KeyStatePrimary {
+			return v
+		}
+	}
+	return nil
+}
+
+// version 返回指定版本
+func (k *ManagedKey) version(version int) *KeyVersion {
+	for _, v := range k.Versions {
+		if v.Version == version {
+			return v
+		}
+	}
+	return nil
+}
+
+// LocalKeyManager 基于本地文件的KeyManager实现。
+// 所有密钥序列化成JSON后用masterKey以AES-GCM加密存入文件，每次变更后原子地重写文件
+type LocalKeyManager struct {
+	sync.RWMutex
+	path      string
+	masterKey []byte
+	keys      map[string]*ManagedKey // key:name, value:*ManagedKey
+}
+
+// NewLocalKeyManager 生成LocalKeyManager实例，path存在时从文件加载密钥，masterKey为AES密钥
+func NewLocalKeyManager(path string, masterKey []byte) (*LocalKeyManager, error) {
+	km := &LocalKeyManager{path: path, masterKey: masterKey, keys: map[string]*ManagedKey{}}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// 校验masterKey是否合法
+		if _, err = newGCM(masterKey); err != nil {
+			return nil, err
+		}
+		return km, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := AESDecryptGCM(masterKey, buf, []byte(keyStoreAdditionalData))
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(plaintext, &km.keys); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+// CreateKey 创建密钥，返回第一个版本的密钥ID
+func (km *LocalKeyManager) CreateKey(name, purpose string) (string, error) {
+	if name == "" || len(name) > 200 || strings.ContainsAny(name, "/.") {
+		return "", fmt.Errorf("key name invalid:%s", name)
+	}
+	if purpose != KeyPurposeEncrypt && purpose != KeyPurposeSign {
+		return "", fmt.Errorf("unsupport key purpose:%s", purpose)
+	}
+	km.Lock()
+	defer km.Unlock()
+	if _, ok := km.keys[name]; ok {
+		return "", fmt.Errorf("this key already exist:%s", name)
+	}
+	key := &ManagedKey{Name: name, Purpose: purpose}
+	v, err := newKeyVersion(purpose, 1)
+	if err != nil {
+		return "", err
+	}
+	key.Versions = append(key.Versions, v)
+	km.keys[name] = key
+	if err = km.save(); err != nil {
+		delete(km.keys, name)
+		return "", err
+	}
+	return formatKeyID(name, v.Version), nil
+}
+
+// Rotate 生成新版本并设为主版本，原主版本降为active，返回新的密钥ID
+func (km *LocalKeyManager) Rotate(name string) (string, error) {
+	km.Lock()
+	defer km.Unlock()
+	key, ok := km.keys[name]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	v, err := newKeyVersion(key.Purpose, key.Versions[len(key.Versions)-1].Version+1)
+	if err != nil {
+		return "", err
+	}
+	old := key.primary()
+	if old != nil {
+		old.State = KeyStateActive
+	}
+	key.Versions = append(key.Versions, v)
+	if err = km.save(); err != nil {
+		key.Versions = key.Versions[:len(key.Versions)-1]
+		if old != nil {
+			old.State = KeyStatePrimary
+		}
+		return "", err
+	}
+	return formatKeyID(name, v.Version), nil
+}
+
+// Retire 将一个非主版本退役，退役后不能再用于解密、验签
+func (km *LocalKeyManager) Retire(keyID string) error {
+	km.Lock()
+	defer km.Unlock()
+	_, v, err := km.lookup(keyID)
+	if err != nil {
+		return err
+	}
+	if v.State == KeyStatePrimary {
+		return fmt.Errorf("can not retire primary key:%s", keyID)
+	}
+	state := v.State
+	v.State = KeyStateRetired
+	if err = km.save(); err != nil {
+		v.State = state
+		return err
+	}
+	return nil
+}
+
+// Keys 返回所有密钥的名字，按字典序排列
+func (km *LocalKeyManager) Keys() []string {
+	km.RLock()
+	defer km.RUnlock()
+	names := make([]string, 0, len(km.keys))
+	for name := range km.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Versions 返回密钥未退役的版本ID，主版本在前，其余按版本号从新到旧
+func (km *LocalKeyManager) Versions(name string) ([]string, error) {
+	km.RLock()
+	defer km.RUnlock()
+	key, ok := km.keys[name]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	var versions []string
+	if v := key.primary(); v != nil {
+		versions = append(versions, formatKeyID(name, v.Version))
+	}
+	for i := len(key.Versions) - 1; i >= 0; i-- {
+		if v := key.Versions[i]; v.State == KeyStateActive {
+			versions = append(versions, formatKeyID(name, v.Version))
+		}
+	}
+	return versions, nil
+}
+
+// HMAC 用加密密钥的指定版本计算HMAC-SHA256，MAC密钥由密钥材料经HKDF派生，不直接使用AES密钥
+func (km *LocalKeyManager) HMAC(keyID string, data []byte) ([]byte, error) {
+	km.RLock()
+	defer km.RUnlock()
+	v, err := km.usable(keyID, KeyPurposeEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := HKDF(crypto.SHA256, v.Material, nil, keyAdditionalData(keyID, []byte(keyHMACInfo)), DerivedKeySize)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// Signer 返回签名密钥指定版本的私钥，已退役的版本不可用
+func (km *LocalKeyManager) Signer(keyID string) (crypto.Signer, error) {
+	km.RLock()
+	defer km.RUnlock()
+	v, err := km.usable(keyID, KeyPurposeSign)
+	if err != nil {
+		return nil, err
+	}
+	return LoadAnyPrivateKey(v.Material)
+}
+
+// VerifyKey 返回签名密钥指定版本的公钥，已退役的版本返回ErrKeyRetired
+func (km *LocalKeyManager) VerifyKey(keyID string) (crypto.PublicKey, error) {
+	km.RLock()
+	defer km.RUnlock()
+	v, err := km.usable(keyID, KeyPurposeSign)
+	if err != nil {
+		return nil, err
+	}
+	return LoadAnyPublicKey([]byte(v.PublicKey))
+}
+
+// Encrypt 用主版本加密，输出格式为 keyIDLen(1字节) | keyID | GCM信封，keyID同时作为附加认证数据
+func (km *LocalKeyManager) Encrypt(name string, plaintext, additionalData []byte) ([]byte, error) {
+	km.RLock()
+	defer km.RUnlock()
+	keyID, v, err := km.primary(name, KeyPurposeEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	body, err := AESEncryptGCM(v.Material, plaintext, keyAdditionalData(keyID, additionalData))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 1+len(keyID)+len(body))
+	out = append(out, byte(len(keyID)))
+	out = append(out, keyID...)
+	return append(out, body...), nil
+}
+
+// Decrypt 根据密文中的密钥ID选择版本解密
+func (km *LocalKeyManager) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext) < 1+int(ciphertext[0]) {
+		return nil, ErrKeyID
+	}
+	keyID := string(ciphertext[1 : 1+int(ciphertext[0])])
+	km.RLock()
+	defer km.RUnlock()
+	v, err := km.usable(keyID, KeyPurposeEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	return AESDecryptGCM(v.Material, ciphertext[1+len(keyID):], keyAdditionalData(keyID, additionalData))
+}
+
+// Sign 用主版本签名，输出格式为 密钥ID.base64签名
+func (km *LocalKeyManager) Sign(name string, data []byte) (string, error) {
+	km.RLock()
+	defer km.RUnlock()
+	keyID, v, err := km.primary(name, KeyPurposeSign)
+	if err != nil {
+		return "", err
+	}
+	sign, err := SignWithKey(string(v.Material), string(keyAdditionalData(keyID, data)))
+	if err != nil {
+		return "", err
+	}
+	return keyID + "." + sign, nil
+}
+
+// Verify 根据签名中的密钥ID选择版本验签
+func (km *LocalKeyManager) Verify(data []byte, sign string) error {
+	idx := strings.LastIndex(sign, ".")
+	if idx <= 0 {
+		return ErrKeyID
+	}
+	keyID := sign[:idx]
+	km.RLock()
+	defer km.RUnlock()
+	v, err := km.usable(keyID, KeyPurposeSign)
+	if err != nil {
+		return err
+	}
+	return VerifyWithKey(v.PublicKey, string(keyAdditionalData(keyID, data)), sign[idx+1:])
+}
+
+// PublicKey 获取签名密钥版本对应的PEM公钥，已退役的版本也可以获取
+func (km *LocalKeyManager) PublicKey(keyID string) (string, error) {
+	km.RLock()
+	defer km.RUnlock()
+	key, v, err := km.lookup(keyID)
+	if err != nil {
+		return "", err
+	}
+	if key.Purpose != KeyPurposeSign {
+		return "", ErrKeyPurpose
+	}
+	return v.PublicKey, nil
+}
+
+// primary 返回name的主版本，调用方需持有锁
+func (km *LocalKeyManager) primary(name, purpose string) (string, *KeyVersion, error) {
+	key, ok := km.keys[name]
+	if !ok {
+		return "", nil, ErrKeyNotFound
+	}
+	if key.Purpose != purpose {
+		return "", nil, ErrKeyPurpose
+	}
+	v := key.primary()
+	if v == nil {
+		return "", nil, ErrKeyNotFound
+	}
+	return formatKeyID(name, v.Version), v, nil
+}
+
+// usable 返回可用于解密、验签的版本，调用方需持有锁
+func (km *LocalKeyManager) usable(keyID, purpose string) (*KeyVersion, error) {
+	key, v, err := km.lookup(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Purpose != purpose {
+		return nil, ErrKeyPurpose
+	}
+	if v.State == KeyStateRetired {
+		return nil, ErrKeyRetired
+	}
+	return v, nil
+}
+
+// lookup 根据密钥ID查找版本，调用方需持有锁
+func (km *LocalKeyManager) lookup(keyID string) (*ManagedKey, *KeyVersion, error) {
+	name, version, err := ParseKeyID(keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := km.keys[name]
+	if !ok {
+		return nil, nil, ErrKeyNotFound
+	}
+	v := key.version(version)
+	if v == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+	return key, v, nil
+}
+
+// save 加密后原子地写入文件，调用方需持有写锁
+func (km *LocalKeyManager) save() error {
+	plaintext, err := json.Marshal(km.keys)
+	if err != nil {
+		return err
+	}
+	buf, err := AESEncryptGCM(km.masterKey, plaintext, []byte(keyStoreAdditionalData))
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(km.path), filepath.Base(km.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), km.path)
+}
+
+// ParseKeyID 解析 name/v版本号 格式的密钥ID
+func ParseKeyID(keyID string) (name string, version int, err error) {
+	name, v, ok := strings.Cut(keyID, "/v")
+	if !ok || name == "" {
+		return "", 0, ErrKeyID
+	}
+	version, err = strconv.Atoi(v)
+	if err != nil || version <= 0 {
+		return "", 0, ErrKeyID
+	}
+	return name, version, nil
+}
+
+// formatKeyID 生成密钥ID
+func formatKeyID(name string, version int) string {
+	return fmt.Sprintf("%s/v%d", name, version)
+}
+
+// newKeyVersion 生成一个新的主版本，加密密钥为AES-256，签名密钥为ECDSA P-256
+func newKeyVersion(purpose string, version int) (*KeyVersion, error) {
+	v := &KeyVersion{Version: version, State: KeyStatePrimary, CreatedAt: time.Now()}
+	switch purpose {
+	case KeyPurposeEncrypt:
+		v.Material = make([]byte, 32)
+		if _, err := rand.Read(v.Material); err != nil {
+			return nil, err
+		}
+	case KeyPurposeSign:
+		prikey, pubkey, err := GenerateECDSAKeyStr(elliptic.P256())
+		if err != nil {
+			return nil, err
+		}
+		v.Material, v.PublicKey = []byte(prikey), pubkey
+	}
+	return v, nil
+}
+
+// keyAdditionalData 将密钥ID加入附加数据，防止密文、签名被替换成其它版本
+func keyAdditionalData(keyID string, data []byte) []byte {
+	ad := make([]byte, 0, len(keyID)+1+len(data))
+	ad = append(ad, keyID...)
+	ad = append(ad, 0)
+	return append(ad, data...)
+}
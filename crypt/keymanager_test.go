@@ -0,0 +1,180 @@
+package crypt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var masterKey = []byte("E15E2A40282E42E01163B2643C208505")
+
+func TestLocalKeyManagerEncrypt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	var km KeyManager
+	km, err := NewLocalKeyManager(path, masterKey)
+	assert.NoError(t, err)
+
+	keyID, err := km.CreateKey("tenant-data", KeyPurposeEncrypt)
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-data/v1", keyID)
+	_, err = km.CreateKey("tenant-data", KeyPurposeEncrypt)
+	assert.Error(t, err)
+
+	ad := []byte("Tencent700")
+	ciphertext1, err := km.Encrypt("tenant-data", []byte("test crypt"), ad)
+	assert.NoError(t, err)
+
+	// 轮换后新密文使用新版本，旧密文仍可解密
+	keyID2, err := km.Rotate("tenant-data")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-data/v2", keyID2)
+	ciphertext2, err := km.Encrypt("tenant-data", []byte("test crypt"), ad)
+	assert.NoError(t, err)
+	assert.Equal(t, keyID2, string(ciphertext2[1:1+ciphertext2[0]]))
+
+	for _, c := range [][]byte{ciphertext1, ciphertext2} {
+		plain, err := km.Decrypt(c, ad)
+		assert.NoError(t, err)
+		assert.Equal(t, "test crypt", string(plain))
+	}
+	_, err = km.Decrypt(ciphertext1, []byte("Tencent600"))
+	assert.ErrorIs(t, err, ErrGCMAuth)
+
+	// 篡改密钥ID
+	tampered := append([]byte{}, ciphertext1...)
+	tampered[len("tenant-data/v")+1] = '2'
+	_, err = km.Decrypt(tampered, ad)
+	assert.ErrorIs(t, err, ErrGCMAuth)
+
+	// 退役旧版本
+	assert.Error(t, km.Retire(keyID2))
+	assert.NoError(t, km.Retire(keyID))
+	_, err = km.Decrypt(ciphertext1, ad)
+	assert.ErrorIs(t, err, ErrKeyRetired)
+
+	// 重新加载后状态保持
+	km2, err := NewLocalKeyManager(path, masterKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant-data"}, km2.Keys())
+	plain, err := km2.Decrypt(ciphertext2, ad)
+	assert.NoError(t, err)
+	assert.Equal(t, "test crypt", string(plain))
+	_, err = km2.Decrypt(ciphertext1, ad)
+	assert.ErrorIs(t, err, ErrKeyRetired)
+
+	// masterKey错误无法加载
+	_, err = NewLocalKeyManager(path, []byte("E15E2A40282E42E01163B2643C208506"))
+	assert.ErrorIs(t, err, ErrGCMAuth)
+
+	// 文件中不包含明文密钥材料
+	buf, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(buf), "tenant-data")
+}
+
+func TestLocalKeyManagerSign(t *testing.T) {
+	km, err := NewLocalKeyManager(filepath.Join(t.TempDir(), "keys.db"), masterKey)
+	assert.NoError(t, err)
+
+	keyID, err := km.CreateKey("merchant-sign", KeyPurposeSign)
+	assert.NoError(t, err)
+	sign1, err := km.Sign("merchant-sign", []byte("Tencent:AppID1"))
+	assert.NoError(t, err)
+	assert.NoError(t, km.Verify([]byte("Tencent:AppID1"), sign1))
+	assert.Error(t, km.Verify([]byte("Tencent:AppID2"), sign1))
+
+	pubkey, err := km.PublicKey(keyID)
+	assert.NoError(t, err)
+	assert.Contains(t, pubkey, "PUBLIC KEY")
+
+	_, err = km.Rotate("merchant-sign")
+	assert.NoError(t, err)
+	sign2, err := km.Sign("merchant-sign", []byte("Tencent:AppID1"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, sign1[:len(keyID)], sign2[:len(keyID)])
+	assert.NoError(t, km.Verify([]byte("Tencent:AppID1"), sign1))
+	assert.NoError(t, km.Verify([]byte("Tencent:AppID1"), sign2))
+
+	assert.NoError(t, km.Retire(keyID))
+	assert.ErrorIs(t, km.Verify([]byte("Tencent:AppID1"), sign1), ErrKeyRetired)
+
+	// 用途不匹配
+	_, err = km.Encrypt("merchant-sign", []byte("test crypt"), nil)
+	assert.ErrorIs(t, err, ErrKeyPurpose)
+	_, err = km.Sign("not-exist", []byte("test crypt"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorIs(t, km.Verify([]byte("test crypt"), "nosign"), ErrKeyID)
+}
+
+func TestLocalKeyManagerVersions(t *testing.T) {
+	var km KeyManager
+	km, err := NewLocalKeyManager(filepath.Join(t.TempDir(), "keys.db"), masterKey)
+	assert.NoError(t, err)
+
+	macID1, err := km.CreateKey("token-hash", KeyPurposeEncrypt)
+	assert.NoError(t, err)
+	mac1, err := km.HMAC(macID1, []byte("token"))
+	assert.NoError(t, err)
+	assert.Len(t, mac1, 32)
+	again, err := km.HMAC(macID1, []byte("token"))
+	assert.NoError(t, err)
+	assert.Equal(t, mac1, again)
+
+	macID2, err := km.Rotate("token-hash")
+	assert.NoError(t, err)
+	macID3, err := km.Rotate("token-hash")
+	assert.NoError(t, err)
+	versions, err := km.Versions("token-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{macID3, macID2, macID1}, versions)
+	mac2, err := km.HMAC(macID2, []byte("token"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, mac1, mac2)
+
+	assert.NoError(t, km.Retire(macID1))
+	versions, err = km.Versions("token-hash")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{macID3, macID2}, versions)
+	_, err = km.HMAC(macID1, []byte("token"))
+	assert.ErrorIs(t, err, ErrKeyRetired)
+	_, err = km.Versions("not-exist")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	signID, err := km.CreateKey("jwt-sign", KeyPurposeSign)
+	assert.NoError(t, err)
+	signer, err := km.Signer(signID)
+	assert.NoError(t, err)
+	pub, err := km.VerifyKey(signID)
+	assert.NoError(t, err)
+	token, err := SignJWS(signer, signID, []byte(`{"sub":"Tencent"}`))
+	assert.NoError(t, err)
+	_, _, err = VerifyJWS(pub, token)
+	assert.NoError(t, err)
+
+	// 用途不匹配
+	_, err = km.HMAC(signID, []byte("token"))
+	assert.ErrorIs(t, err, ErrKeyPurpose)
+	_, err = km.Signer(macID2)
+	assert.ErrorIs(t, err, ErrKeyPurpose)
+
+	_, err = km.Rotate("jwt-sign")
+	assert.NoError(t, err)
+	assert.NoError(t, km.Retire(signID))
+	_, err = km.VerifyKey(signID)
+	assert.ErrorIs(t, err, ErrKeyRetired)
+	assert.Equal(t, []string{"jwt-sign", "token-hash"}, km.Keys())
+}
+
+func TestParseKeyID(t *testing.T) {
+	name, version, err := ParseKeyID("tenant-data/v12")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-data", name)
+	assert.Equal(t, 12, version)
+
+	for _, bad := range []string{"", "tenant-data", "tenant-data/v", "tenant-data/v0", "/v1", "tenant-data/vx"} {
+		_, _, err = ParseKeyID(bad)
+		assert.ErrorIs(t, err, ErrKeyID, bad)
+	}
+}
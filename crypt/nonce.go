@@ -0,0 +1,77 @@
+package crypt
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileNonceStore 基于文件的NonceStore，进程重启后已使用的nonce仍然有效，仅适用于单机。
+// 每次记录nonce都会清理过期项并原子地重写整个文件
+type FileNonceStore struct {
+	sync.Mutex
+	path   string
+	nonces map[string]int64 // key:nonce, value:过期时间，Unix秒
+	now    func() time.Time
+}
+
+// NewFileNonceStore 生成FileNonceStore实例，文件不存在时会在首次记录nonce时创建
+func NewFileNonceStore(path string) (*FileNonceStore, error) {
+	s := &FileNonceStore{path: path, nonces: map[string]int64{}, now: time.Now}
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &s.nonces); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Use 记录nonce，同时清理已过期的nonce
+func (s *FileNonceStore) Use(nonce string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now().Unix()
+	if expiry, ok := s.nonces[nonce]; ok && expiry > now {
+		return ErrNonceReused
+	}
+	for k, expiry := range s.nonces {
+		if expiry <= now {
+			delete(s.nonces, k)
+		}
+	}
+	s.nonces[nonce] = s.now().Add(ttl).Unix()
+	if err := s.save(); err != nil {
+		delete(s.nonces, nonce)
+		return err
+	}
+	return nil
+}
+
+// save 原子地写入文件，调用方需持有锁
+func (s *FileNonceStore) save() error {
+	buf, err := json.Marshal(s.nonces)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
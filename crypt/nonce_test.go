@@ -0,0 +1,32 @@
+package crypt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileNonceStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	path := filepath.Join(t.TempDir(), "nonces.json")
+	s, err := NewFileNonceStore(path)
+	assert.NoError(t, err)
+	s.now = func() time.Time { return now }
+	assert.NoError(t, s.Use("a", time.Minute))
+	assert.NoError(t, s.Use("b", time.Hour))
+	assert.Equal(t, ErrNonceReused, s.Use("a", time.Minute))
+
+	// 重新加载后已使用的nonce仍然有效
+	s, err = NewFileNonceStore(path)
+	assert.NoError(t, err)
+	s.now = func() time.Time { return now }
+	assert.Equal(t, ErrNonceReused, s.Use("a", time.Minute))
+
+	// 过期后可以再次使用，过期项被清理
+	now = now.Add(time.Minute)
+	assert.NoError(t, s.Use("a", time.Minute))
+	assert.Equal(t, ErrNonceReused, s.Use("b", time.Minute))
+	assert.Len(t, s.nonces, 2)
+}
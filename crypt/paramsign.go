@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ParamSignOptions 参数签名规则，兼容常见支付网关"按key排序、k=v&拼接、末尾追加key=密钥"的约定
+type ParamSignOptions struct {
+	Exclude   []string // 不参与签名的字段，如sign、sign_type
+	KeepEmpty bool     // 空值是否参与签名，默认跳过
+	SecretKey string   // 末尾追加&SecretKey=SignParams.Secret，为空时不追加
+	SignField string   // 校验回调时从参数中读取签名的字段名
+}
+
+// DefaultParamSignOptions 返回默认规则：排除sign、sign_type，跳过空值，末尾追加key=密钥
+func DefaultParamSignOptions() *ParamSignOptions {
+	return &ParamSignOptions{
+		Exclude:   []string{"sign", "sign_type"},
+		SecretKey: "key",
+		SignField: "sign",
+	}
+}
+
+// BuildParamString 按规则生成待签名字符串：key按字典序排序，以k=v&k=v拼接，
+// 最后按需追加&SecretKey=secret；值不做转义
+func BuildParamString(params map[string]string, secret string, opts *ParamSignOptions) string {
+	exclude := make(map[string]bool, len(opts.Exclude))
+	for _, k := range opts.Exclude {
+		exclude[k] = true
+	}
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if exclude[k] || (v == "" && !opts.KeepEmpty) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+	if opts.SecretKey != "" {
+		pairs = append(pairs, opts.SecretKey+"="+secret)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// SignParamMap 对参数签名，待签名字符串见BuildParamString，签名算法与输出格式由signParams决定
+func SignParamMap(params map[string]string, signParams *SignParams, opts *ParamSignOptions) (string, error) {
+	return CalcSign(BuildParamString(params, signParams.Secret, opts), signParams)
+}
+
+// VerifyParamMap 校验回调参数的签名，签名从params[opts.SignField]读取
+func VerifyParamMap(params map[string]string, signParams *SignParams, opts *ParamSignOptions) error {
+	sign, ok := params[opts.SignField]
+	if !ok || sign == "" {
+		return fmt.Errorf("sign field not exist:%s", opts.SignField)
+	}
+	return VerifySign(BuildParamString(params, signParams.Secret, opts), sign, signParams)
+}
+
+// SignValues 对url.Values签名，每个key只取第一个值
+func SignValues(values url.Values, signParams *SignParams, opts *ParamSignOptions) (string, error) {
+	return SignParamMap(ValuesToMap(values), signParams, opts)
+}
+
+// VerifyValues 校验url.Values形式的回调参数签名，如表单回调，每个key只取第一个值
+func VerifyValues(values url.Values, signParams *SignParams, opts *ParamSignOptions) error {
+	return VerifyParamMap(ValuesToMap(values), signParams, opts)
+}
+
+// ValuesToMap 将url.Values转为map，每个key只取第一个值
+func ValuesToMap(values url.Values) map[string]string {
+	params := make(map[string]string, len(values))
+	for k := range values {
+		params[k] = values.Get(k)
+	}
+	return params
+}
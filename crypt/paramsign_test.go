@@ -0,0 +1,57 @@
+package crypt
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignParamMap(t *testing.T) {
+	// 微信支付文档中的签名示例
+	params := map[string]string{
+		"appid":       "wxd930ea5d5a258f4f",
+		"mch_id":      "10000100",
+		"device_info": "1000",
+		"body":        "test",
+		"nonce_str":   "ibuaiVcKdpRxkhJA",
+		"attach":      "",
+	}
+	secret := "192006250b4c09247ec02edce69f6a2d"
+	opts := DefaultParamSignOptions()
+	assert.Equal(t, "appid=wxd930ea5d5a258f4f&body=test&device_info=1000&mch_id=10000100"+
+		"&nonce_str=ibuaiVcKdpRxkhJA&key=192006250b4c09247ec02edce69f6a2d", BuildParamString(params, secret, opts))
+
+	signParams := &SignParams{SignType: SignMD5, Secret: secret, Md5Upper: true}
+	sign, err := SignParamMap(params, signParams, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "9A0A8659F005D6984697E2CA0A9CF3B7", sign)
+
+	// 回调中的sign、sign_type不参与签名
+	params["sign"], params["sign_type"] = sign, "MD5"
+	assert.NoError(t, VerifyParamMap(params, signParams, opts))
+	params["body"] = "test2"
+	assert.Equal(t, ErrSignMismatch, VerifyParamMap(params, signParams, opts))
+	delete(params, "sign")
+	assert.Error(t, VerifyParamMap(params, signParams, opts))
+
+	opts = &ParamSignOptions{Exclude: []string{"sign"}, KeepEmpty: true}
+	assert.Equal(t, "a=&b=2", BuildParamString(map[string]string{"b": "2", "a": "", "sign": "x"}, secret, opts))
+}
+
+func TestSignValues(t *testing.T) {
+	values := url.Values{"b": {"2", "3"}, "a": {"1"}}
+	opts := DefaultParamSignOptions()
+	for _, signParams := range []*SignParams{
+		{SignType: SignHmacSha1, Secret: "Secret", SafeBase64: true},
+		{SignType: SignSHA256WithRSA, PrivateKey: privateKey, PublicKey: publicKey},
+	} {
+		sign, err := SignValues(values, signParams, opts)
+		assert.NoError(t, err)
+		values.Set("sign", sign)
+		assert.NoError(t, VerifyValues(values, signParams, opts))
+		values.Set("a", "2")
+		assert.Error(t, VerifyValues(values, signParams, opts))
+		values.Set("a", "1")
+	}
+}
@@ -0,0 +1,273 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 常量定义
+const (
+	// 口令哈希算法
+	PasswordArgon2id     = "argon2id"
+	PasswordBcrypt       = "bcrypt"
+	PasswordPBKDF2SHA256 = "pbkdf2-sha256"
+	PasswordPBKDF2SHA512 = "pbkdf2-sha512"
+)
+
+var (
+	// ErrPasswordMismatch 口令与哈希不匹配
+	ErrPasswordMismatch = errors.New("password mismatch")
+	// ErrPasswordHash 口令哈希字符串格式错误
+	ErrPasswordHash = errors.New("password hash invalid")
+)
+
+// PasswordParams 口令哈希参数，按Algorithm使用对应的字段
+type PasswordParams struct {
+	Algorithm string
+	// argon2id：迭代次数、内存(KiB)、并行度
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	// bcrypt：代价因子
+	Cost int
+	// pbkdf2：迭代次数
+	Iterations int
+	// argon2id、pbkdf2：盐长度与输出长度，单位字节
+	SaltLen int
+	KeyLen  int
+}
+
+// DefaultPasswordParams 返回推荐的口令哈希参数，默认使用Argon2id
+func DefaultPasswordParams() *PasswordParams {
+	return &PasswordParams{
+		Algorithm:  PasswordArgon2id,
+		Time:       3,
+		Memory:     64 * 1024,
+		Threads:    2,
+		Cost:       12,
+		Iterations: 600000,
+		SaltLen:    16,
+		KeyLen:     32,
+	}
+}
+
+// HashPassword 计算口令哈希，返回PHC格式字符串，其中记录了算法与参数：
+// argon2id: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+// pbkdf2:   $pbkdf2-sha256$i=600000$<salt>$<hash>
+// bcrypt:   $2a$12$<salt+hash>，使用bcrypt自带的格式
+// salt与hash为不带填充的标准base64
+func HashPassword(password string, params *PasswordParams) (string, error) {
+	if params.Algorithm == PasswordBcrypt {
+		buf, err := bcrypt.GenerateFromPassword([]byte(password), params.Cost)
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	if params.SaltLen < 8 || params.KeyLen < 16 {
+		return "", fmt.Errorf("password salt or key length too short: %d,%d", params.SaltLen, params.KeyLen)
+	}
+	salt := make([]byte, params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	switch params.Algorithm {
+	case PasswordArgon2id:
+		if params.Time == 0 || params.Memory == 0 || params.Threads == 0 ||
+			params.Time > maxArgon2Time || params.Memory > maxArgon2Memory {
+			return "", fmt.Errorf("argon2id params invalid: %+v", params)
+		}
+		key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(params.KeyLen))
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordArgon2id, argon2.Version,
+			params.Memory, params.Time, params.Threads, phcEncode(salt), phcEncode(key)), nil
+	case PasswordPBKDF2SHA256, PasswordPBKDF2SHA512:
+		if params.Iterations <= 0 || params.Iterations > maxPBKDF2Iterations {
+			return "", fmt.Errorf("pbkdf2 iterations invalid: %d", params.Iterations)
+		}
+		key := pbkdf2.Key([]byte(password), salt, params.Iterations, params.KeyLen, pbkdf2Hash(params.Algorithm))
+		return fmt.Sprintf("$%s$i=%d$%s$%s", params.Algorithm, params.Iterations,
+			phcEncode(salt), phcEncode(key)), nil
+	default:
+		return "", fmt.Errorf("unsupport password algorithm: %v", params.Algorithm)
+	}
+}
+
+// VerifyPassword 校验口令，算法与参数从哈希字符串中读取，比较以常量时间完成。
+// 口令不匹配时返回ErrPasswordMismatch
+func VerifyPassword(password, encoded string) error {
+	if isBcryptHash(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+
+	phc, err := parsePHC(encoded)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	switch phc.params.Algorithm {
+	case PasswordArgon2id:
+		key = argon2.IDKey([]byte(password), phc.salt, phc.params.Time, phc.params.Memory,
+			phc.params.Threads, uint32(len(phc.hash)))
+	default:
+		key = pbkdf2.Key([]byte(password), phc.salt, phc.params.Iterations, len(phc.hash),
+			pbkdf2Hash(phc.params.Algorithm))
+	}
+	if subtle.ConstantTimeCompare(key, phc.hash) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash 判断口令哈希的算法或参数是否与params不一致，
+// 一致时返回false；不一致或无法解析时返回true，调用方应在口令校验通过后重新计算哈希
+func NeedsRehash(encoded string, params *PasswordParams) bool {
+	if isBcryptHash(encoded) {
+		if params.Algorithm != PasswordBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != params.Cost
+	}
+
+	phc, err := parsePHC(encoded)
+	if err != nil || phc.params.Algorithm != params.Algorithm {
+		return true
+	}
+	if len(phc.salt) != params.SaltLen || len(phc.hash) != params.KeyLen {
+		return true
+	}
+	switch params.Algorithm {
+	case PasswordArgon2id:
+		return phc.params.Time != params.Time || phc.params.Memory != params.Memory ||
+			phc.params.Threads != params.Threads
+	default:
+		return phc.params.Iterations != params.Iterations
+	}
+}
+
+// 解析哈希时允许的参数上限，防止构造的哈希字符串耗尽内存或CPU
+const (
+	maxArgon2Memory     = 4 * 1024 * 1024 // KiB，即4GiB
+	maxArgon2Time       = 64
+	maxArgon2Threads    = 255
+	maxPBKDF2Iterations = 10000000
+)
+
+// phcHash 解析后的PHC格式哈希
+type phcHash struct {
+	params *PasswordParams
+	salt   []byte
+	hash   []byte
+}
+
+// parsePHC 解析argon2id、pbkdf2的PHC格式字符串
+func parsePHC(encoded string) (*phcHash, error) {
+	// 以$开头，分割后第一段为空
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return nil, ErrPasswordHash
+	}
+	params := &PasswordParams{Algorithm: parts[1]}
+	var fields []string
+	switch params.Algorithm {
+	case PasswordArgon2id:
+		if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+			return nil, ErrPasswordHash
+		}
+		fields = parts[3:]
+	case PasswordPBKDF2SHA256, PasswordPBKDF2SHA512:
+		if len(parts) != 5 {
+			return nil, ErrPasswordHash
+		}
+		fields = parts[2:]
+	default:
+		return nil, fmt.Errorf("unsupport password algorithm: %v", params.Algorithm)
+	}
+
+	for _, kv := range strings.Split(fields[0], ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, ErrPasswordHash
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 {
+			return nil, ErrPasswordHash
+		}
+		switch k {
+		case "m":
+			if n > maxArgon2Memory {
+				return nil, ErrPasswordHash
+			}
+			params.Memory = uint32(n)
+		case "t":
+			if n > maxArgon2Time {
+				return nil, ErrPasswordHash
+			}
+			params.Time = uint32(n)
+		case "p":
+			if n > maxArgon2Threads {
+				return nil, ErrPasswordHash
+			}
+			params.Threads = uint8(n)
+		case "i":
+			if n > maxPBKDF2Iterations {
+				return nil, ErrPasswordHash
+			}
+			params.Iterations = int(n)
+		default:
+			return nil, ErrPasswordHash
+		}
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, ErrPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil || len(key) == 0 {
+		return nil, ErrPasswordHash
+	}
+	if params.Algorithm == PasswordArgon2id && (params.Time == 0 || params.Memory == 0 || params.Threads == 0) {
+		return nil, ErrPasswordHash
+	}
+	if params.Algorithm != PasswordArgon2id && params.Iterations == 0 {
+		return nil, ErrPasswordHash
+	}
+	params.SaltLen, params.KeyLen = len(salt), len(key)
+	return &phcHash{params: params, salt: salt, hash: key}, nil
+}
+
+// isBcryptHash 判断是否为bcrypt格式的哈希
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// pbkdf2Hash 返回pbkdf2使用的哈希算法
+func pbkdf2Hash(algorithm string) func() hash.Hash {
+	if algorithm == PasswordPBKDF2SHA512 {
+		return sha512.New
+	}
+	return sha256.New
+}
+
+// phcEncode PHC格式使用不带填充的标准base64
+func phcEncode(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
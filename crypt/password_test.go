@@ -0,0 +1,144 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// testPasswordParams 测试用的低代价参数
+func testPasswordParams(algorithm string) *PasswordParams {
+	return &PasswordParams{
+		Algorithm:  algorithm,
+		Time:       1,
+		Memory:     1024,
+		Threads:    1,
+		Cost:       4,
+		Iterations: 1000,
+		SaltLen:    16,
+		KeyLen:     32,
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{PasswordArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{PasswordBcrypt, "$2a$04$"},
+		{PasswordPBKDF2SHA256, "$pbkdf2-sha256$i=1000$"},
+		{PasswordPBKDF2SHA512, "$pbkdf2-sha512$i=1000$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			params := testPasswordParams(tt.algorithm)
+			encoded, err := HashPassword("test crypt", params)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(encoded, tt.prefix), encoded)
+
+			// 相同口令每次哈希结果不同
+			encoded2, err := HashPassword("test crypt", params)
+			assert.NoError(t, err)
+			assert.NotEqual(t, encoded, encoded2)
+
+			assert.NoError(t, VerifyPassword("test crypt", encoded))
+			assert.ErrorIs(t, VerifyPassword("test crypt?", encoded), ErrPasswordMismatch)
+			assert.False(t, NeedsRehash(encoded, params))
+		})
+	}
+}
+
+func TestVerifyPasswordKnown(t *testing.T) {
+	// 手工构造的PHC字符串
+	encoded := "$pbkdf2-sha256$i=1000$c2FsdHNhbHRzYWx0c2FsdA$" +
+		phcEncode(mustPBKDF2("password", "saltsaltsaltsalt", 1000))
+	assert.NoError(t, VerifyPassword("password", encoded))
+
+	for _, bad := range []string{
+		"",
+		"plain",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!$aGFzaA",
+		"$pbkdf2-sha256$i=0$c2FsdA$aGFzaA",
+		"$pbkdf2-sha256$x=1$c2FsdA$aGFzaA",
+	} {
+		assert.Error(t, VerifyPassword("password", bad), bad)
+		assert.True(t, NeedsRehash(bad, DefaultPasswordParams()), bad)
+	}
+	assert.Error(t, VerifyPassword("password", "$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA"))
+}
+
+func TestNeedsRehash(t *testing.T) {
+	params := testPasswordParams(PasswordArgon2id)
+	encoded, err := HashPassword("test crypt", params)
+	assert.NoError(t, err)
+
+	// 参数升级
+	upgraded := testPasswordParams(PasswordArgon2id)
+	upgraded.Time = 2
+	assert.True(t, NeedsRehash(encoded, upgraded))
+	upgraded = testPasswordParams(PasswordArgon2id)
+	upgraded.KeyLen = 64
+	assert.True(t, NeedsRehash(encoded, upgraded))
+
+	// 算法升级
+	assert.True(t, NeedsRehash(encoded, testPasswordParams(PasswordBcrypt)))
+
+	bcryptHash, err := HashPassword("test crypt", testPasswordParams(PasswordBcrypt))
+	assert.NoError(t, err)
+	upgraded = testPasswordParams(PasswordBcrypt)
+	upgraded.Cost = 5
+	assert.True(t, NeedsRehash(bcryptHash, upgraded))
+	assert.True(t, NeedsRehash(bcryptHash, params))
+
+	pbkdf2Hash, err := HashPassword("test crypt", testPasswordParams(PasswordPBKDF2SHA256))
+	assert.NoError(t, err)
+	upgraded = testPasswordParams(PasswordPBKDF2SHA256)
+	upgraded.Iterations = 2000
+	assert.True(t, NeedsRehash(pbkdf2Hash, upgraded))
+	assert.True(t, NeedsRehash(pbkdf2Hash, testPasswordParams(PasswordPBKDF2SHA512)))
+}
+
+func TestHashPasswordInvalid(t *testing.T) {
+	params := testPasswordParams("md5")
+	_, err := HashPassword("test crypt", params)
+	assert.Error(t, err)
+
+	params = testPasswordParams(PasswordArgon2id)
+	params.SaltLen = 4
+	_, err = HashPassword("test crypt", params)
+	assert.Error(t, err)
+
+	params = testPasswordParams(PasswordPBKDF2SHA256)
+	params.Iterations = 0
+	_, err = HashPassword("test crypt", params)
+	assert.Error(t, err)
+
+	params.Iterations = maxPBKDF2Iterations + 1
+	_, err = HashPassword("test crypt", params)
+	assert.Error(t, err)
+}
+
+func TestVerifyPasswordParamsLimit(t *testing.T) {
+	salt, key := phcEncode([]byte("saltsalt")), phcEncode(make([]byte, 32))
+	for _, encoded := range []string{
+		fmt.Sprintf("$argon2id$v=19$m=%d,t=1,p=1$%s$%s", maxArgon2Memory+1, salt, key),
+		fmt.Sprintf("$argon2id$v=19$m=1024,t=%d,p=1$%s$%s", maxArgon2Time+1, salt, key),
+		fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=%d$%s$%s", maxArgon2Threads+1, salt, key),
+		fmt.Sprintf("$pbkdf2-sha256$i=%d$%s$%s", maxPBKDF2Iterations+1, salt, key),
+		fmt.Sprintf("$pbkdf2-sha512$i=%d$%s$%s", uint64(1)<<40, salt, key),
+	} {
+		assert.ErrorIs(t, VerifyPassword("test crypt", encoded), ErrPasswordHash, encoded)
+		assert.True(t, NeedsRehash(encoded, DefaultPasswordParams()))
+	}
+}
+
+func mustPBKDF2(password, salt string, iterations int) []byte {
+	return pbkdf2.Key([]byte(password), []byte(salt), iterations, 32, sha256.New)
+}
@@ -0,0 +1,349 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 常量定义
+const (
+	// PKCS#8加密私钥使用的密钥派生算法
+	KDFPBKDF2 = "pbkdf2"
+	KDFScrypt = "scrypt"
+
+	encryptedPrivateKeyType = "ENCRYPTED PRIVATE KEY"
+
+	// 加载加密私钥时允许的scrypt参数上限，防止构造的私钥耗尽内存或CPU。
+	// PBKDF2迭代次数上限同maxPBKDF2Iterations
+	maxScryptMemory   = 1 << 30 // 128*r*N字节，即1GiB
+	maxScryptParallel = 16
+)
+
+var (
+	// ErrEncryptedPrivateKey 私钥已加密，需使用LoadEncryptedPrivateKey加载
+	ErrEncryptedPrivateKey = errors.New("private key is encrypted")
+	// ErrPassphrase 口令错误或加密私钥已损坏
+	ErrPassphrase = errors.New("passphrase incorrect or private key corrupted")
+)
+
+// 算法OID，见RFC 8018、RFC 7914、RFC 3565
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// PKCS8Options 加密私钥的参数，KDF为KDFPBKDF2时使用Iterations，为KDFScrypt时使用N、R、P
+type PKCS8Options struct {
+	KDF        string
+	Iterations int
+	N          int
+	R          int
+	P          int
+	KeySize    int // AES密钥长度：16、24、32
+}
+
+// DefaultPKCS8Options 返回默认参数：scrypt(N=16384,r=8,p=1)+AES-256-CBC，与openssl -scrypt默认值一致
+func DefaultPKCS8Options() *PKCS8Options {
+	return &PKCS8Options{
+		KDF:        KDFScrypt,
+		Iterations: 600000,
+		N:          1 << 14,
+		R:          8,
+		P:          1,
+		KeySize:    32,
+	}
+}
+
+// encryptedPrivateKeyInfo 见RFC 5958
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params 见RFC 8018 A.4
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params 见RFC 8018 A.2
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// scryptParams 见RFC 7914 7.1
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// DumpEncryptedPrivateKey 转存口令加密的私钥，格式为PKCS#8 PBES2，PEM类型为ENCRYPTED PRIVATE KEY。
+// key支持*rsa.PrivateKey、*ecdsa.PrivateKey、ed25519.PrivateKey，opts为nil时使用DefaultPKCS8Options
+func DumpEncryptedPrivateKey(key crypto.PrivateKey, passphrase []byte, opts *PKCS8Options) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase can not be empty")
+	}
+	if opts == nil {
+		opts = DefaultPKCS8Options()
+	}
+	plaintext, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	encOID, err := pkcs8CipherOID(opts.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	iv := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err = rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	var kdf pkix.AlgorithmIdentifier
+	var derived []byte
+	switch opts.KDF {
+	case KDFPBKDF2:
+		if opts.Iterations <= 0 || opts.Iterations > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("pbkdf2 iterations invalid: %d", opts.Iterations)
+		}
+		derived = pbkdf2.Key(passphrase, salt, opts.Iterations, opts.KeySize, sha256.New)
+		kdf, err = marshalAlgorithm(oidPBKDF2, pbkdf2Params{
+			Salt:           salt,
+			IterationCount: opts.Iterations,
+			KeyLength:      opts.KeySize,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+	case KDFScrypt:
+		if err = checkScryptParams(opts.N, opts.R, opts.P); err != nil {
+			return nil, err
+		}
+		if derived, err = scrypt.Key(passphrase, salt, opts.N, opts.R, opts.P, opts.KeySize); err != nil {
+			return nil, err
+		}
+		kdf, err = marshalAlgorithm(oidScrypt, scryptParams{
+			Salt:                     salt,
+			CostParameter:            opts.N,
+			BlockSize:                opts.R,
+			ParallelizationParameter: opts.P,
+			KeyLength:                opts.KeySize,
+		})
+	default:
+		return nil, fmt.Errorf("unsupport kdf: %v", opts.KDF)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedData, err := AESEncryptCBC(derived, iv, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := marshalAlgorithm(encOID, iv)
+	if err != nil {
+		return nil, err
+	}
+	algo, err := marshalAlgorithm(oidPBES2, pbes2Params{KeyDerivationFunc: kdf, EncryptionScheme: enc})
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{Algo: algo, EncryptedData: encryptedData})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: encryptedPrivateKeyType, Bytes: der}), nil
+}
+
+// LoadEncryptedPrivateKey 获取口令加密的私钥，支持PBES2(PBKDF2/scrypt + AES-CBC)。
+// 返回*rsa.PrivateKey、*ecdsa.PrivateKey或ed25519.PrivateKey；口令错误时返回ErrPassphrase
+func LoadEncryptedPrivateKey(privateKey, passphrase []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("get private key error")
+	}
+	if block.Type != encryptedPrivateKeyType {
+		return nil, fmt.Errorf("unexpected pem type: %v", block.Type)
+	}
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(block.Bytes, &info); err != nil || len(rest) > 0 {
+		return nil, errors.New("encrypted private key asn1 invalid")
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupport encryption algorithm: %v", info.Algo.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.New("pbes2 params invalid")
+	}
+
+	keySize, err := pkcs8CipherKeySize(params.EncryptionScheme.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != 16 {
+		return nil, errors.New("pbes2 iv invalid")
+	}
+
+	var derived []byte
+	kdf := params.KeyDerivationFunc
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var p pbkdf2Params
+		if _, err = asn1.Unmarshal(kdf.Parameters.FullBytes, &p); err != nil || p.IterationCount <= 0 ||
+			p.IterationCount > maxPBKDF2Iterations {
+			return nil, errors.New("pbkdf2 params invalid")
+		}
+		if p.KeyLength != 0 && p.KeyLength != keySize {
+			return nil, errors.New("pbkdf2 key length mismatch")
+		}
+		var prf func() hash.Hash
+		if prf, err = pkcs8PRF(p.PRF.Algorithm); err != nil {
+			return nil, err
+		}
+		derived = pbkdf2.Key(passphrase, p.Salt, p.IterationCount, keySize, prf)
+	case kdf.Algorithm.Equal(oidScrypt):
+		var p scryptParams
+		if _, err = asn1.Unmarshal(kdf.Parameters.FullBytes, &p); err != nil {
+			return nil, errors.New("scrypt params invalid")
+		}
+		if p.KeyLength != 0 && p.KeyLength != keySize {
+			return nil, errors.New("scrypt key length mismatch")
+		}
+		if err = checkScryptParams(p.CostParameter, p.BlockSize, p.ParallelizationParameter); err != nil {
+			return nil, err
+		}
+		derived, err = scrypt.Key(passphrase, p.Salt, p.CostParameter, p.BlockSize, p.ParallelizationParameter, keySize)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupport kdf: %v", kdf.Algorithm)
+	}
+
+	plaintext, err := AESDecryptCBC(derived, iv, info.EncryptedData)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plaintext)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupport private key type: %T", key)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		if err = checkCurve(k.Curve); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
+
+// GenerateKeyStrWithPassphrase 生成RSA密钥对，私钥使用passphrase加密后转成字符串形式
+func GenerateKeyStrWithPassphrase(passphrase string) (prikey, pubkey string, err error) {
+	privatekey, publickey, err := GenerateKey()
+	if err != nil {
+		return "", "", err
+	}
+	var pribuf []byte
+	if pribuf, err = DumpEncryptedPrivateKey(privatekey, []byte(passphrase), nil); err != nil {
+		return
+	}
+	var pubbuf []byte
+	if pubbuf, err = DumpPublicKey(publickey); err != nil {
+		return
+	}
+	return string(pribuf), string(pubbuf), nil
+}
+
+// checkScryptParams 校验scrypt参数，内存占用128*r*N不超过maxScryptMemory，p不超过maxScryptParallel
+func checkScryptParams(n, r, p int) error {
+	if n <= 1 || r <= 0 || p <= 0 || p > maxScryptParallel || n > maxScryptMemory/128/r {
+		return fmt.Errorf("scrypt params invalid: N=%d,r=%d,p=%d", n, r, p)
+	}
+	return nil
+}
+
+// marshalAlgorithm 生成带参数的AlgorithmIdentifier
+func marshalAlgorithm(oid asn1.ObjectIdentifier, params interface{}) (pkix.AlgorithmIdentifier, error) {
+	buf, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: buf}}, nil
+}
+
+// pkcs8CipherOID 根据AES密钥长度返回CBC模式的OID
+func pkcs8CipherOID(keySize int) (asn1.ObjectIdentifier, error) {
+	switch keySize {
+	case 16:
+		return oidAES128CBC, nil
+	case 24:
+		return oidAES192CBC, nil
+	case 32:
+		return oidAES256CBC, nil
+	default:
+		return nil, fmt.Errorf("unsupport aes key size: %d", keySize)
+	}
+}
+
+// pkcs8CipherKeySize 根据OID返回AES密钥长度
+func pkcs8CipherKeySize(oid asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return 16, nil
+	case oid.Equal(oidAES192CBC):
+		return 24, nil
+	case oid.Equal(oidAES256CBC):
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupport encryption scheme: %v", oid)
+	}
+}
+
+// pkcs8PRF 根据OID返回PBKDF2使用的PRF，缺省为HMAC-SHA1
+func pkcs8PRF(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupport pbkdf2 prf: %v", oid)
+	}
+}
@@ -0,0 +1,283 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求签名使用的HTTP头
+const (
+	HeaderSignTimestamp = "X-Sign-Timestamp"
+	HeaderSignNonce     = "X-Sign-Nonce"
+	HeaderSignature     = "X-Sign-Signature"
+
+	// DefaultMaxSkew 默认允许的客户端与服务端时钟偏差
+	DefaultMaxSkew = time.Minute * 5
+
+	maxNonceLength = 128
+)
+
+var (
+	// ErrRequestExpired 请求时间戳超出允许的时钟偏差
+	ErrRequestExpired = errors.New("request timestamp out of allowed skew")
+	// ErrNonceReused 请求nonce已经使用过，疑似重放
+	ErrNonceReused = errors.New("request nonce already used")
+	// ErrNonceInvalid 请求nonce为空或过长
+	ErrNonceInvalid = errors.New("request nonce invalid")
+)
+
+// CanonicalRequest 参与签名的请求要素。
+// 规范化字符串为以下各行用\n连接：
+// 1) 大写的HTTP方法
+// 2) 转义后的路径，为空时为/
+// 3) 按key、value排序的query，key和value按RFC 3986转义后以k=v&k=v拼接
+// 4) Unix时间戳，单位秒
+// 5) nonce
+// 6) body的SHA-256摘要，小写hex
+type CanonicalRequest struct {
+	Method    string
+	Path      string
+	Query     url.Values
+	Body      []byte
+	Timestamp int64
+	Nonce     string
+}
+
+// NewCanonicalRequest 生成CanonicalRequest实例，时间戳为当前时间，nonce为随机生成
+func NewCanonicalRequest(method, path string, query url.Values, body []byte) (*CanonicalRequest, error) {
+	nonce, err := RandomNonce()
+	if err != nil {
+		return nil, err
+	}
+	return &CanonicalRequest{
+		Method:    method,
+		Path:      path,
+		Query:     query,
+		Body:      body,
+		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+	}, nil
+}
+
+// String 返回规范化字符串
+func (r *CanonicalRequest) String() string {
+	path := r.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		strings.ToUpper(r.Method),
+		path,
+		CanonicalQuery(r.Query),
+		strconv.FormatInt(r.Timestamp, 10),
+		r.Nonce,
+		Sha256Bytes(r.Body, false),
+	}, "\n")
+}
+
+// CanonicalQuery 将query按key、value排序后拼接，key和value按RFC 3986转义
+func CanonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, rfc3986Escape(k)+"="+rfc3986Escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// rfc3986Escape 按RFC 3986转义，空格为%20，~不转义
+func rfc3986Escape(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
+
+// RandomNonce 生成32个字符的随机nonce
+func RandomNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// SignRequest 使用私钥对请求签名，根据私钥类型选择算法，见SignWithKey
+func SignRequest(privateKey string, req *CanonicalRequest) (string, error) {
+	if req.Nonce == "" || len(req.Nonce) > maxNonceLength {
+		return "", ErrNonceInvalid
+	}
+	return SignWithKey(privateKey, req.String())
+}
+
+// SignHTTPRequest 对HTTP请求签名，设置时间戳、nonce、签名三个请求头。
+// 会读取并恢复r.Body
+func SignHTTPRequest(privateKey string, r *http.Request) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	req, err := NewCanonicalRequest(r.Method, r.URL.EscapedPath(), r.URL.Query(), body)
+	if err != nil {
+		return err
+	}
+	sign, err := SignRequest(privateKey, req)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(HeaderSignTimestamp, strconv.FormatInt(req.Timestamp, 10))
+	r.Header.Set(HeaderSignNonce, req.Nonce)
+	r.Header.Set(HeaderSignature, sign)
+	return nil
+}
+
+// NonceStore 记录已使用的nonce，用于防重放
+type NonceStore interface {
+	// Use 记录nonce，ttl之后可以过期删除；nonce已存在且未过期时返回ErrNonceReused
+	Use(nonce string, ttl time.Duration) error
+}
+
+// MemoryNonceStore 基于内存的NonceStore，仅适用于单实例
+type MemoryNonceStore struct {
+	sync.Mutex
+	nonces map[string]time.Time // key:nonce, value:过期时间
+	now    func() time.Time
+}
+
+// NewMemoryNonceStore 生成MemoryNonceStore实例
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}, now: time.Now}
+}
+
+// Use 记录nonce，同时清理已过期的nonce
+func (s *MemoryNonceStore) Use(nonce string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	if expiry, ok := s.nonces[nonce]; ok && expiry.After(now) {
+		return ErrNonceReused
+	}
+	for k, expiry := range s.nonces {
+		if !expiry.After(now) {
+			delete(s.nonces, k)
+		}
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return nil
+}
+
+// RequestVerifier 请求验签，校验签名、时钟偏差与nonce唯一性
+type RequestVerifier struct {
+	MaxSkew time.Duration    // 允许的时钟偏差，<=0时使用DefaultMaxSkew
+	Nonces  NonceStore       // 为nil时不校验nonce唯一性
+	Now     func() time.Time // 为nil时使用time.Now
+}
+
+// NewRequestVerifier 生成使用默认时钟偏差与内存NonceStore的RequestVerifier实例
+func NewRequestVerifier() *RequestVerifier {
+	return &RequestVerifier{MaxSkew: DefaultMaxSkew, Nonces: NewMemoryNonceStore()}
+}
+
+// Verify 使用公钥校验请求签名。
+// 依次检查nonce格式、时间戳是否在允许偏差内、签名是否正确，最后记录nonce
+func (v *RequestVerifier) Verify(publicKey string, req *CanonicalRequest, sign string) error {
+	return v.VerifyMessage(publicKey, req.String(), req.Timestamp, req.Nonce, sign)
+}
+
+// VerifyMessage 使用公钥校验绑定了时间戳与nonce的消息签名，message中必须包含timestamp与nonce。
+// 时间戳超出允许偏差时返回ErrRequestExpired，nonce重复时返回ErrNonceReused；
+// nonce以公钥为作用域，不同商户的nonce互不影响
+func (v *RequestVerifier) VerifyMessage(publicKey, message string, timestamp int64, nonce, sign string) error {
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return ErrNonceInvalid
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	skew := now().Sub(time.Unix(timestamp, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return ErrRequestExpired
+	}
+	if err := VerifyWithKey(publicKey, message, sign); err != nil {
+		return err
+	}
+	if v.Nonces == nil {
+		return nil
+	}
+	// 超出偏差窗口的请求会被时间戳校验拒绝，nonce只需保留两倍窗口
+	return v.Nonces.Use(Sha256String(publicKey, false)[:16]+":"+nonce, maxSkew*2)
+}
+
+// VerifyHTTP 校验由SignHTTPRequest签名的HTTP请求，会读取并恢复r.Body
+func (v *RequestVerifier) VerifyHTTP(publicKey string, r *http.Request) error {
+	req, sign, err := ParseHTTPRequest(r)
+	if err != nil {
+		return err
+	}
+	return v.Verify(publicKey, req, sign)
+}
+
+// ParseHTTPRequest 从HTTP请求中解析出CanonicalRequest与签名，会读取并恢复r.Body。
+// 路径与查询参数取自r.RequestURI，即客户端签名时的完整路径，r.RequestURI为空时取自r.URL
+func ParseHTTPRequest(r *http.Request) (*CanonicalRequest, string, error) {
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderSignTimestamp), 10, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("request header %s invalid", HeaderSignTimestamp)
+	}
+	sign := r.Header.Get(HeaderSignature)
+	if sign == "" {
+		return nil, "", fmt.Errorf("request header %s missing", HeaderSignature)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return nil, "", err
+	}
+	// 服务端收到的请求按RequestURI中的原始路径校验，经http.StripPrefix等改写URL后签名仍然有效，
+	// 且前缀(如租户ID)同样受签名保护
+	u := r.URL
+	if r.RequestURI != "" {
+		if u, err = url.ParseRequestURI(r.RequestURI); err != nil {
+			return nil, "", err
+		}
+	}
+	req := &CanonicalRequest{
+		Method:    r.Method,
+		Path:      u.EscapedPath(),
+		Query:     u.Query(),
+		Body:      body,
+		Timestamp: timestamp,
+		Nonce:     r.Header.Get(HeaderSignNonce),
+	}
+	return req, sign, nil
+}
+
+// readBody 读取请求体后恢复，便于后续处理
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,97 @@
+package crypt
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalRequest(t *testing.T) {
+	req := &CanonicalRequest{
+		Method:    "post",
+		Path:      "/v1/orders",
+		Query:     url.Values{"b": {"2", "1"}, "a": {"x y~*"}},
+		Body:      []byte("test crypt"),
+		Timestamp: 1700000000,
+		Nonce:     "abc",
+	}
+	expected := "POST\n/v1/orders\na=x%20y~%2A&b=1&b=2\n1700000000\nabc\n" +
+		"6e3c9b9746f646545b1d9939c201c62ad4a83052edf12eead44c1089410b2c8c"
+	assert.Equal(t, expected, req.String())
+
+	req.Path, req.Query, req.Body = "", nil, nil
+	assert.Equal(t, "POST\n/\n\n1700000000\nabc\n"+
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", req.String())
+}
+
+func TestRequestVerifier(t *testing.T) {
+	privateKey, publicKey, err := GenerateEd25519KeyStr()
+	assert.NoError(t, err)
+	_, otherPublicKey, err := GenerateEd25519KeyStr()
+	assert.NoError(t, err)
+
+	req, err := NewCanonicalRequest("PUT", "/v1/orders/1", url.Values{"a": {"1"}}, []byte(`{"amount":100}`))
+	assert.NoError(t, err)
+	sign, err := SignRequest(privateKey, req)
+	assert.NoError(t, err)
+
+	v := NewRequestVerifier()
+	assert.Error(t, v.Verify(otherPublicKey, req, sign))
+	assert.NoError(t, v.Verify(publicKey, req, sign))
+	assert.Equal(t, ErrNonceReused, v.Verify(publicKey, req, sign))
+
+	// 篡改任一要素都会导致验签失败
+	tampered := *req
+	tampered.Body = []byte(`{"amount":1}`)
+	assert.Error(t, v.Verify(publicKey, &tampered, sign))
+	tampered = *req
+	tampered.Query = url.Values{"a": {"2"}}
+	assert.Error(t, v.Verify(publicKey, &tampered, sign))
+
+	// 超出时钟偏差
+	v = NewRequestVerifier()
+	v.Now = func() time.Time { return time.Unix(req.Timestamp, 0).Add(DefaultMaxSkew + time.Second) }
+	assert.Equal(t, ErrRequestExpired, v.Verify(publicKey, req, sign))
+	v.Now = func() time.Time { return time.Unix(req.Timestamp, 0).Add(-DefaultMaxSkew - time.Second) }
+	assert.Equal(t, ErrRequestExpired, v.Verify(publicKey, req, sign))
+
+	req.Nonce = ""
+	_, err = SignRequest(privateKey, req)
+	assert.Equal(t, ErrNonceInvalid, err)
+	assert.Equal(t, ErrNonceInvalid, v.Verify(publicKey, req, sign))
+}
+
+func TestSignHTTPRequest(t *testing.T) {
+	privateKey, publicKey, err := GenerateKeyStr()
+	assert.NoError(t, err)
+
+	body := []byte(`{"amount":100}`)
+	r := httptest.NewRequest("POST", "/v1/orders?b=2&a=1", bytes.NewReader(body))
+	assert.NoError(t, SignHTTPRequest(privateKey, r))
+	assert.NotEmpty(t, r.Header.Get(HeaderSignature))
+
+	v := NewRequestVerifier()
+	assert.NoError(t, v.VerifyHTTP(publicKey, r))
+	// 请求体被恢复，可以继续读取
+	buf, err := io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, buf)
+
+	r.Header.Del(HeaderSignTimestamp)
+	assert.Error(t, v.VerifyHTTP(publicKey, r))
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemoryNonceStore()
+	s.now = func() time.Time { return now }
+	assert.NoError(t, s.Use("a", time.Minute))
+	assert.Equal(t, ErrNonceReused, s.Use("a", time.Minute))
+	now = now.Add(time.Minute)
+	assert.NoError(t, s.Use("a", time.Minute))
+}
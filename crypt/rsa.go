@@ -2,11 +2,13 @@ package crypt
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"math"
 )
 
@@ -28,6 +30,30 @@ func RSADecrypt(key, ciphertext []byte) ([]byte, error) {
 	return rsa.DecryptPKCS1v15(rand.Reader, prikey, ciphertext)
 }
 
+// RSAEncryptOAEP RSA OAEP模式公钥加密，label为可选的标签，解密时需要一致
+func RSAEncryptOAEP(key, plaintext, label []byte, hash crypto.Hash) ([]byte, error) {
+	pubkey, err := LoadPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("unsupport hash: %v", hash)
+	}
+	return rsa.EncryptOAEP(hash.New(), rand.Reader, pubkey, plaintext, label)
+}
+
+// RSADecryptOAEP RSA OAEP模式私钥解密
+func RSADecryptOAEP(key, ciphertext, label []byte, hash crypto.Hash) ([]byte, error) {
+	prikey, err := LoadPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("unsupport hash: %v", hash)
+	}
+	return rsa.DecryptOAEP(hash.New(), rand.Reader, prikey, ciphertext, label)
+}
+
 // GenerateKey 生成RSA密钥对, 包括private和public key
 func GenerateKey() (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	privatekey, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -103,6 +129,9 @@ func LoadPrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
 	if block == nil {
 		return nil, errors.New("get private key error")
 	}
+	if block.Type == encryptedPrivateKeyType {
+		return nil, ErrEncryptedPrivateKey
+	}
 	pri, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err == nil {
 		return pri, nil
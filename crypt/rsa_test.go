@@ -1,6 +1,7 @@
 package crypt
 
 import (
+	"crypto"
 	"fmt"
 	"strings"
 	"testing"
@@ -91,3 +92,21 @@ func TestFormatKeyToMultiline(t *testing.T) {
 	fmtKey2 := FormatKeyToMultiline([]byte(key2), true)
 	assert.Equal(t, prikey, string(fmtKey2), "should equal")
 }
+
+func TestRSAEncryptOAEP(t *testing.T) {
+	plain := "test crypt"
+	label := []byte("merchant")
+	result, err := RSAEncryptOAEP([]byte(publicKey), []byte(plain), label, crypto.SHA256)
+	assert.NoError(t, err)
+
+	result2, err := RSADecryptOAEP([]byte(privateKey), result, label, crypto.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, string(result2), "should equal")
+
+	// label不一致
+	_, err = RSADecryptOAEP([]byte(privateKey), result, []byte("other"), crypto.SHA256)
+	assert.Error(t, err)
+	// 哈希算法不一致
+	_, err = RSADecryptOAEP([]byte(privateKey), result, label, crypto.SHA1)
+	assert.Error(t, err)
+}
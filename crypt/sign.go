@@ -12,11 +12,17 @@ import (
 // 常量定义
 const (
 	// 签名方式
-	SignMD5           = "MD5"
-	SignHmacMD5       = "HMAC-MD5"
-	SignHmacSha1      = "HMAC-SHA1"
-	SignSHA1WithRSA   = "SHA1WithRSA"
-	SignSHA256WithRSA = "SHA256WithRSA"
+	SignMD5              = "MD5"
+	SignHmacMD5          = "HMAC-MD5"
+	SignHmacSha1         = "HMAC-SHA1"
+	SignSHA1WithRSA      = "SHA1WithRSA"
+	SignSHA256WithRSA    = "SHA256WithRSA"
+	SignSHA256WithRSAPSS = "SHA256WithRSAPSS"
+	SignSHA384WithRSAPSS = "SHA384WithRSAPSS"
+	SignSHA512WithRSAPSS = "SHA512WithRSAPSS"
+	SignSHA256WithECDSA  = "SHA256WithECDSA"
+	SignSHA384WithECDSA  = "SHA384WithECDSA"
+	SignEd25519          = "Ed25519"
 )
 
 // SignParams 签名参数
@@ -26,28 +32,18 @@ type SignParams struct {
 	Secret     string
 	Md5Upper   bool
 	SafeBase64 bool
+	PublicKey  string // 验签时使用
 }
 
-// CalcSign 计算签名
+// CalcSign 计算签名，签名算法通过RegisterSigner注册
 func CalcSign(data string, params *SignParams) (sign string, err error) {
-	switch params.SignType {
-	case SignMD5:
-		sign = Md5String(data, params.Md5Upper)
-	case SignHmacMD5:
-		sign, err = HmacMD5Base64(data, params.Secret)
-	case SignHmacSha1:
-		sign, err = HmacSha1Base64(data, params.Secret)
-	case SignSHA1WithRSA:
-		sign, err = SignSha1WithRsa(params.PrivateKey, data)
-	case SignSHA256WithRSA:
-		sign, err = SignSha256WithRsa(params.PrivateKey, data)
-	default:
-		err = fmt.Errorf("unsupport sign type: %v", params.SignType)
-	}
-
+	signer, err := GetSigner(params.SignType)
 	if err != nil {
 		return "", err
 	}
+	if sign, err = signer.Sign(data, params); err != nil {
+		return "", err
+	}
 
 	if params.SafeBase64 {
 		sign = tryToURLSafeBase64(sign)
@@ -55,6 +51,18 @@ func CalcSign(data string, params *SignParams) (sign string, err error) {
 	return sign, nil
 }
 
+// VerifySign 验证签名，是CalcSign的逆过程，params与计算签名时一致，非对称算法需设置PublicKey
+func VerifySign(data, sign string, params *SignParams) error {
+	verifier, err := GetSigner(params.SignType)
+	if err != nil {
+		return err
+	}
+	if params.SafeBase64 {
+		sign = tryFromURLSafeBase64(sign)
+	}
+	return verifier.Verify(data, sign, params)
+}
+
 // tryToURLSafeBase64 尝试将base64转url safe base64，如果非base64，原样返回
 func tryToURLSafeBase64(data string) string {
 	if v, err := base64.StdEncoding.DecodeString(data); err == nil {
@@ -63,6 +71,14 @@ func tryToURLSafeBase64(data string) string {
 	return data
 }
 
+// tryFromURLSafeBase64 尝试将url safe base64转回标准base64，如果非url safe base64，原样返回
+func tryFromURLSafeBase64(data string) string {
+	if v, err := base64.RawURLEncoding.DecodeString(data); err == nil {
+		data = base64.StdEncoding.EncodeToString(v)
+	}
+	return data
+}
+
 // SignPKCS1v15 使用RSA PKCS1v15算法签名
 func SignPKCS1v15(key, src []byte, hash crypto.Hash) (string, error) {
 	prikey, err := LoadPrivateKey(key)
@@ -93,6 +109,46 @@ func VerifyPKCS1v15(key, src, sig []byte, hash crypto.Hash) error {
 	return rsa.VerifyPKCS1v15(pubkey, hash, hashed, signBuf)
 }
 
+// SignPSS 使用RSA PSS算法签名。
+// saltLength为盐长度，可以是rsa.PSSSaltLengthEqualsHash、rsa.PSSSaltLengthAuto或具体字节数
+func SignPSS(key, src []byte, hash crypto.Hash, saltLength int) (string, error) {
+	prikey, err := LoadPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	if !hash.Available() {
+		return "", fmt.Errorf("unsupport hash: %v", hash)
+	}
+	var h = hash.New()
+	h.Write(src)
+	hashed := h.Sum(nil)
+
+	opts := &rsa.PSSOptions{SaltLength: saltLength, Hash: hash}
+	signByte, err := rsa.SignPSS(rand.Reader, prikey, hash, hashed, opts)
+	return base64.StdEncoding.EncodeToString(signByte), err
+}
+
+// VerifyPSS 使用RSA PSS验证签名，saltLength为rsa.PSSSaltLengthAuto时自动识别盐长度
+func VerifyPSS(key, src, sig []byte, hash crypto.Hash, saltLength int) error {
+	signBuf, err := base64.StdEncoding.DecodeString(string(sig))
+	if err != nil {
+		return err
+	}
+	pubkey, err := LoadPublicKey(key)
+	if err != nil {
+		return err
+	}
+	if !hash.Available() {
+		return fmt.Errorf("unsupport hash: %v", hash)
+	}
+	var h = hash.New()
+	h.Write(src)
+	hashed := h.Sum(nil)
+
+	opts := &rsa.PSSOptions{SaltLength: saltLength, Hash: hash}
+	return rsa.VerifyPSS(pubkey, hash, hashed, signBuf, opts)
+}
+
 // SignSha1WithRsa 使用RSAWithSHA1算法签名
 func SignSha1WithRsa(privateKey, data string) (string, error) {
 	return SignPKCS1v15([]byte(privateKey), []byte(data), crypto.SHA1)
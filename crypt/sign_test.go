@@ -1,6 +1,8 @@
 package crypt
 
 import (
+	"crypto"
+	"crypto/rsa"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,6 +24,28 @@ func TestSignSha256WithRsa(t *testing.T) {
 	assert.NoError(t, VerifySignSha256WithRsa(publicKey, "hello", sign))
 }
 
+func TestSignPSS(t *testing.T) {
+	for _, saltLength := range []int{rsa.PSSSaltLengthEqualsHash, rsa.PSSSaltLengthAuto, 20} {
+		sign, err := SignPSS([]byte(privateKey), []byte("hello"), crypto.SHA256, saltLength)
+		assert.Greater(t, len(sign), 0, "sign should not be empty")
+		assert.NoError(t, err)
+
+		assert.NoError(t, VerifyPSS([]byte(publicKey), []byte("hello"), []byte(sign), crypto.SHA256, saltLength))
+		assert.NoError(t, VerifyPSS([]byte(publicKey), []byte("hello"), []byte(sign), crypto.SHA256, rsa.PSSSaltLengthAuto))
+		assert.Error(t, VerifyPSS([]byte(publicKey), []byte("hello?"), []byte(sign), crypto.SHA256, saltLength))
+	}
+
+	// 未链接的哈希算法返回错误而不是panic
+	_, err := SignPSS([]byte(privateKey), []byte("hello"), crypto.Hash(0), rsa.PSSSaltLengthAuto)
+	assert.Error(t, err)
+	assert.Error(t, VerifyPSS([]byte(publicKey), []byte("hello"), []byte("c2lnbg=="), crypto.Hash(0), rsa.PSSSaltLengthAuto))
+
+	// PKCS1v15签名不能通过PSS验签
+	sign, err := SignSha256WithRsa(privateKey, "hello")
+	assert.NoError(t, err)
+	assert.Error(t, VerifyPSS([]byte(publicKey), []byte("hello"), []byte(sign), crypto.SHA256, rsa.PSSSaltLengthAuto))
+}
+
 func TestCalcSign(t *testing.T) {
 	type args struct {
 		data   string
@@ -34,11 +58,14 @@ func TestCalcSign(t *testing.T) {
 		wantErr  bool
 	}{
 		// TODO: Add test cases.
-		{"SignMD5", args{"Hello World!", &SignParams{SignMD5, privateKey, "Secret", true, true}}, "", true},
-		{"SignHmacMD5", args{"Hello World!", &SignParams{SignHmacMD5, privateKey, "Secret", true, true}}, "", true},
-		{"SignHmacSha1", args{"Hello World!", &SignParams{SignHmacSha1, privateKey, "Secret", true, true}}, "", true},
-		{"SignSHA1WithRSA", args{"Hello World!", &SignParams{SignSHA1WithRSA, privateKey, "Secret", true, true}}, "", true},
-		{"SignSHA256WithRSA", args{"Hello World!", &SignParams{SignSHA256WithRSA, privateKey, "Secret", true, true}}, "", true},
+		{"SignMD5", args{"Hello World!", &SignParams{SignMD5, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignHmacMD5", args{"Hello World!", &SignParams{SignHmacMD5, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignHmacSha1", args{"Hello World!", &SignParams{SignHmacSha1, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignSHA1WithRSA", args{"Hello World!", &SignParams{SignSHA1WithRSA, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignSHA256WithRSA", args{"Hello World!", &SignParams{SignSHA256WithRSA, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignSHA256WithRSAPSS", args{"Hello World!", &SignParams{SignSHA256WithRSAPSS, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignSHA384WithRSAPSS", args{"Hello World!", &SignParams{SignSHA384WithRSAPSS, privateKey, "Secret", true, true, publicKey}}, "", true},
+		{"SignSHA512WithRSAPSS", args{"Hello World!", &SignParams{SignSHA512WithRSAPSS, privateKey, "Secret", true, true, publicKey}}, "", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -46,6 +73,8 @@ func TestCalcSign(t *testing.T) {
 			t.Logf("PlainText:%s, CypherText:%v\n", tt.args.data, sign)
 			assert.Greater(t, len(sign), 0, "sign should not be empty")
 			assert.NoError(t, err)
+			assert.NoError(t, VerifySign(tt.args.data, sign, tt.args.params))
+			assert.ErrorIs(t, VerifySign("Hello World?", sign, tt.args.params), ErrSignMismatch)
 		})
 	}
 }
@@ -0,0 +1,163 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// ErrSignMismatch 签名校验不通过
+var ErrSignMismatch = errors.New("sign verification failed")
+
+// Signer 签名算法
+type Signer interface {
+	Sign(data string, params *SignParams) (string, error)
+}
+
+// Verifier 验签算法，sign为标准base64或hex形式，不含url safe转换
+type Verifier interface {
+	Verify(data, sign string, params *SignParams) error
+}
+
+// SignVerifier 同时支持签名和验签的算法
+type SignVerifier interface {
+	Signer
+	Verifier
+}
+
+// signerRegistry 签名算法注册表，key:签名方式，value:签名算法
+var signerRegistry = struct {
+	sync.RWMutex
+	signers map[string]SignVerifier
+}{
+	signers: map[string]SignVerifier{
+		SignMD5:              &md5Signer{},
+		SignHmacMD5:          &hmacSigner{md5.New},
+		SignHmacSha1:         &hmacSigner{sha1.New},
+		SignSHA1WithRSA:      &rsaPKCS1v15Signer{crypto.SHA1},
+		SignSHA256WithRSA:    &rsaPKCS1v15Signer{crypto.SHA256},
+		SignSHA256WithRSAPSS: NewRSAPSSSigner(crypto.SHA256, rsa.PSSSaltLengthEqualsHash),
+		SignSHA384WithRSAPSS: NewRSAPSSSigner(crypto.SHA384, rsa.PSSSaltLengthEqualsHash),
+		SignSHA512WithRSAPSS: NewRSAPSSSigner(crypto.SHA512, rsa.PSSSaltLengthEqualsHash),
+		SignSHA256WithECDSA:  &keySigner{crypto.SHA256},
+		SignSHA384WithECDSA:  &keySigner{crypto.SHA384},
+		SignEd25519:          &keySigner{0},
+	},
+}
+
+// RegisterSigner 注册签名算法，已存在的签名方式会被覆盖，一般在程序启动时调用
+func RegisterSigner(signType string, sv SignVerifier) {
+	signerRegistry.Lock()
+	defer signerRegistry.Unlock()
+	signerRegistry.signers[signType] = sv
+}
+
+// GetSigner 根据签名方式获取签名算法
+func GetSigner(signType string) (SignVerifier, error) {
+	signerRegistry.RLock()
+	defer signerRegistry.RUnlock()
+	sv, ok := signerRegistry.signers[signType]
+	if !ok {
+		return nil, fmt.Errorf("unsupport sign type: %v", signType)
+	}
+	return sv, nil
+}
+
+// md5Signer MD5签名，data中应已包含密钥
+type md5Signer struct{}
+
+// Sign 计算MD5签名
+func (s *md5Signer) Sign(data string, params *SignParams) (string, error) {
+	return Md5String(data, params.Md5Upper), nil
+}
+
+// Verify 校验MD5签名
+func (s *md5Signer) Verify(data, sign string, params *SignParams) error {
+	expect := Md5String(data, params.Md5Upper)
+	if subtle.ConstantTimeCompare([]byte(expect), []byte(sign)) != 1 {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// hmacSigner HMAC签名，结果为base64
+type hmacSigner struct {
+	hash func() hash.Hash
+}
+
+// Sign 计算HMAC签名
+func (s *hmacSigner) Sign(data string, params *SignParams) (string, error) {
+	mac, err := HmacHash(s.hash, data, params.Secret)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(mac), nil
+}
+
+// Verify 以常量时间校验HMAC签名
+func (s *hmacSigner) Verify(data, sign string, params *SignParams) error {
+	signBuf, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return ErrSignMismatch
+	}
+	mac, err := HmacHash(s.hash, data, params.Secret)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, signBuf) {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// rsaPKCS1v15Signer RSA PKCS1v15签名，私钥签名，公钥验签
+type rsaPKCS1v15Signer struct {
+	hash crypto.Hash
+}
+
+// Sign 计算RSA签名
+func (s *rsaPKCS1v15Signer) Sign(data string, params *SignParams) (string, error) {
+	return SignPKCS1v15([]byte(params.PrivateKey), []byte(data), s.hash)
+}
+
+// Verify 校验RSA签名
+func (s *rsaPKCS1v15Signer) Verify(data, sign string, params *SignParams) error {
+	err := VerifyPKCS1v15([]byte(params.PublicKey), []byte(data), []byte(sign), s.hash)
+	if errors.Is(err, rsa.ErrVerification) {
+		return ErrSignMismatch
+	}
+	return err
+}
+
+// rsaPSSSigner RSA PSS签名，私钥签名，公钥验签
+type rsaPSSSigner struct {
+	hash       crypto.Hash
+	saltLength int
+}
+
+// NewRSAPSSSigner 生成指定哈希算法和盐长度的RSA PSS签名算法，可通过RegisterSigner注册
+func NewRSAPSSSigner(hash crypto.Hash, saltLength int) SignVerifier {
+	return &rsaPSSSigner{hash: hash, saltLength: saltLength}
+}
+
+// Sign 计算RSA PSS签名
+func (s *rsaPSSSigner) Sign(data string, params *SignParams) (string, error) {
+	return SignPSS([]byte(params.PrivateKey), []byte(data), s.hash, s.saltLength)
+}
+
+// Verify 校验RSA PSS签名
+func (s *rsaPSSSigner) Verify(data, sign string, params *SignParams) error {
+	err := VerifyPSS([]byte(params.PublicKey), []byte(data), []byte(sign), s.hash, s.saltLength)
+	if errors.Is(err, rsa.ErrVerification) {
+		return ErrSignMismatch
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package crypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reverseSigner 测试用签名算法，签名为数据的逆序
+type reverseSigner struct{}
+
+func (s *reverseSigner) Sign(data string, params *SignParams) (string, error) {
+	b := []byte(data)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b), nil
+}
+
+func (s *reverseSigner) Verify(data, sign string, params *SignParams) error {
+	expect, _ := s.Sign(data, params)
+	if expect != sign {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+func TestRegisterSigner(t *testing.T) {
+	params := &SignParams{SignType: "REVERSE"}
+	_, err := CalcSign("hello", params)
+	assert.Error(t, err)
+	assert.Error(t, VerifySign("hello", "olleh", params))
+
+	RegisterSigner("REVERSE", &reverseSigner{})
+	sign, err := CalcSign("hello", params)
+	assert.NoError(t, err)
+	assert.Equal(t, "olleh", sign)
+	assert.NoError(t, VerifySign("hello", sign, params))
+	assert.ErrorIs(t, VerifySign("hello", "hello", params), ErrSignMismatch)
+
+	sv, err := GetSigner(SignHmacSha1)
+	assert.NoError(t, err)
+	assert.NotNil(t, sv)
+}
+
+func TestVerifySign(t *testing.T) {
+	// MD5大小写与计算时不一致
+	params := &SignParams{SignType: SignMD5, Md5Upper: true}
+	sign, err := CalcSign("test crypt", params)
+	assert.NoError(t, err)
+	assert.Equal(t, "84E3672326017C219590EB44C9DD39F0", sign)
+	assert.NoError(t, VerifySign("test crypt", sign, params))
+	assert.ErrorIs(t, VerifySign("test crypt", "84e3672326017c219590eb44c9dd39f0", params), ErrSignMismatch)
+
+	// HMAC密钥错误
+	params = &SignParams{SignType: SignHmacMD5, Secret: "key"}
+	sign, err = CalcSign("test crypt", params)
+	assert.NoError(t, err)
+	assert.NoError(t, VerifySign("test crypt", sign, params))
+	assert.ErrorIs(t, VerifySign("test crypt", sign, &SignParams{SignType: SignHmacMD5, Secret: "key2"}), ErrSignMismatch)
+	assert.ErrorIs(t, VerifySign("test crypt", "not base64!", params), ErrSignMismatch)
+
+	// RSA缺少公钥
+	params = &SignParams{SignType: SignSHA256WithRSA, PrivateKey: privateKey}
+	sign, err = CalcSign("test crypt", params)
+	assert.NoError(t, err)
+	assert.Error(t, VerifySign("test crypt", sign, params))
+	params.PublicKey = publicKey
+	assert.NoError(t, VerifySign("test crypt", sign, params))
+}
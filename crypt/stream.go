@@ -0,0 +1,253 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 流式加密格式：
+// header: version(1字节) | chunkSize(4字节，大端) | noncePrefix(7字节)
+// segment: AES-GCM(chunk) | tag(16字节)，除最后一个分片外，每个分片明文长度均为chunkSize
+// 每个分片的nonce = noncePrefix(7字节) | 分片序号(4字节，大端) | 结束标记(1字节)
+// header作为每个分片的附加认证数据，分片的重排、删除、截断都会导致解密失败
+const (
+	// StreamVersion1 当前的流式加密格式版本号
+	StreamVersion1 byte = 0x01
+	// StreamChunkSize 默认的分片明文长度
+	StreamChunkSize = 64 * 1024
+
+	streamNoncePrefixSize = 7
+	streamHeaderSize      = 1 + 4 + streamNoncePrefixSize
+	streamMaxChunkSize    = 16 * 1024 * 1024
+)
+
+var (
+	// ErrStreamHeader 流式加密头部格式错误
+	ErrStreamHeader = errors.New("aes stream header invalid")
+	// ErrStreamTruncated 密文流被截断，没有读到结束分片
+	ErrStreamTruncated = errors.New("aes stream truncated")
+	// ErrStreamClosed 加密流已关闭
+	ErrStreamClosed = errors.New("aes stream closed")
+	// ErrStreamTooLarge 分片数量超出上限
+	ErrStreamTooLarge = errors.New("aes stream too large")
+)
+
+// aesStreamWriter 分片加密写入器
+type aesStreamWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	buf       []byte
+	out       []byte
+	counter   uint32
+	closed    bool
+	err       error
+}
+
+// NewAESEncryptWriter 生成AES流式加密写入器，写入的明文被分片加密后写到w。
+// chunkSize为分片明文长度，<=0时使用StreamChunkSize。
+// 调用方必须调用Close写入结束分片，Close不会关闭w
+func NewAESEncryptWriter(key []byte, w io.Writer, chunkSize int) (io.WriteCloser, error) {
+	if chunkSize <= 0 {
+		chunkSize = StreamChunkSize
+	}
+	if chunkSize > streamMaxChunkSize {
+		return nil, ErrStreamHeader
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	header[0] = StreamVersion1
+	binary.BigEndian.PutUint32(header[1:5], uint32(chunkSize))
+	if _, err = rand.Read(header[5:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &aesStreamWriter{
+		w:         w,
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+		out:       make([]byte, 0, chunkSize+GCMTagSize),
+	}, nil
+}
+
+// Write 写入明文，满一个分片且还有后续数据时才加密输出，保证最后一个分片留给Close
+func (sw *aesStreamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrStreamClosed
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(sw.buf) == sw.chunkSize {
+			if sw.err = sw.flush(false); sw.err != nil {
+				return n, sw.err
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):sw.chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close 加密并写入结束分片
+func (sw *aesStreamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.flush(true)
+}
+
+// flush 将缓冲区中的明文加密成一个分片写出
+func (sw *aesStreamWriter) flush(last bool) error {
+	nonce, err := streamNonce(sw.header, sw.counter, last)
+	if err != nil {
+		return err
+	}
+	sw.out = sw.aead.Seal(sw.out[:0], nonce, sw.buf, sw.header)
+	if _, err = sw.w.Write(sw.out); err != nil {
+		return err
+	}
+	sw.buf = sw.buf[:0]
+	sw.counter++
+	return nil
+}
+
+// aesStreamReader 分片解密读取器
+type aesStreamReader struct {
+	r         *bufio.Reader
+	aead      cipher.AEAD
+	header    []byte
+	chunkSize int
+	segment   []byte
+	plainBuf  []byte
+	plain     []byte
+	counter   uint32
+	done      bool
+	err       error
+}
+
+// NewAESDecryptReader 生成AES流式解密读取器，从r读取NewAESEncryptWriter输出的密文流。
+// 读到io.EOF时表示结束分片已经通过认证；密文被截断或篡改时返回错误
+func NewAESDecryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, ErrStreamHeader
+	}
+	if header[0] != StreamVersion1 {
+		return nil, ErrGCMVersion
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[1:5]))
+	if chunkSize <= 0 || chunkSize > streamMaxChunkSize {
+		return nil, ErrStreamHeader
+	}
+	return &aesStreamReader{
+		r:         bufio.NewReader(r),
+		aead:      aead,
+		header:    header,
+		chunkSize: chunkSize,
+		segment:   make([]byte, chunkSize+GCMTagSize),
+		plainBuf:  make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// Read 读取解密后的明文
+func (sr *aesStreamReader) Read(p []byte) (int, error) {
+	for len(sr.plain) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.next()
+	}
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+	return n, nil
+}
+
+// next 读取并解密下一个分片
+func (sr *aesStreamReader) next() error {
+	n, err := io.ReadFull(sr.r, sr.segment)
+	last := false
+	switch err {
+	case nil:
+		// 读满一个分片，后面没有数据则为结束分片
+		if _, perr := sr.r.Peek(1); perr == io.EOF {
+			last = true
+		} else if perr != nil {
+			return perr
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		if n < GCMTagSize {
+			return ErrStreamTruncated
+		}
+		last = true
+	default:
+		return err
+	}
+	nonce, err := streamNonce(sr.header, sr.counter, last)
+	if err != nil {
+		return err
+	}
+	plain, err := sr.aead.Open(sr.plainBuf[:0], nonce, sr.segment[:n], sr.header)
+	if err != nil {
+		if last && sr.isMiddleSegment(n) {
+			// 非结束分片出现在流的末尾，说明密文流被截断
+			return ErrStreamTruncated
+		}
+		return ErrGCMAuth
+	}
+	sr.plain = plain
+	sr.counter++
+	sr.done = last
+	return nil
+}
+
+// isMiddleSegment 判断当前分片能否作为非结束分片通过认证
+func (sr *aesStreamReader) isMiddleSegment(n int) bool {
+	nonce, err := streamNonce(sr.header, sr.counter, false)
+	if err != nil {
+		return false
+	}
+	_, err = sr.aead.Open(nil, nonce, sr.segment[:n], sr.header)
+	return err == nil
+}
+
+// streamNonce 生成第counter个分片的nonce
+func streamNonce(header []byte, counter uint32, last bool) ([]byte, error) {
+	if counter == ^uint32(0) && !last {
+		return nil, ErrStreamTooLarge
+	}
+	nonce := make([]byte, GCMNonceSize)
+	copy(nonce, header[5:])
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], counter)
+	if last {
+		nonce[GCMNonceSize-1] = 0x01
+	}
+	return nonce, nil
+}
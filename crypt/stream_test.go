@@ -0,0 +1,110 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func streamEncrypt(t *testing.T, key, plain []byte, chunkSize int) []byte {
+	var buf bytes.Buffer
+	w, err := NewAESEncryptWriter(key, &buf, chunkSize)
+	assert.NoError(t, err)
+	// 分多次写入，覆盖跨分片的情况
+	for i := 0; i < len(plain); i += 7 {
+		end := i + 7
+		if end > len(plain) {
+			end = len(plain)
+		}
+		_, err = w.Write(plain[i:end])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestAESStream(t *testing.T) {
+	key := []byte("E15E2A40282E42E01163B2643C208505")
+	chunkSize := 32
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, chunkSize * 5, 1000} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		ciphertext := streamEncrypt(t, key, plain, chunkSize)
+
+		r, err := NewAESDecryptReader(key, iotest.OneByteReader(bytes.NewReader(ciphertext)))
+		assert.NoError(t, err)
+		result, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, append([]byte{}, result...), "size %d should equal", size)
+	}
+}
+
+func TestAESStreamTampered(t *testing.T) {
+	key := []byte("E15E2A40282E42E01163B2643C208505")
+	chunkSize := 32
+	plain := bytes.Repeat([]byte("test crypt"), 10)
+	ciphertext := streamEncrypt(t, key, plain, chunkSize)
+	segmentSize := chunkSize + GCMTagSize
+
+	decrypt := func(data []byte) error {
+		r, err := NewAESDecryptReader(key, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	// 在分片边界处截断
+	err := decrypt(ciphertext[:streamHeaderSize+segmentSize*2])
+	assert.ErrorIs(t, err, ErrStreamTruncated)
+
+	// 在分片中间截断，剩余长度不足一个tag
+	err = decrypt(ciphertext[:streamHeaderSize+segmentSize+10])
+	assert.ErrorIs(t, err, ErrStreamTruncated)
+
+	// 在分片中间截断，剩余数据无法通过认证
+	err = decrypt(ciphertext[:streamHeaderSize+segmentSize+20])
+	assert.ErrorIs(t, err, ErrGCMAuth)
+
+	// 篡改密文
+	tampered := append([]byte{}, ciphertext...)
+	tampered[streamHeaderSize+segmentSize+3] ^= 0x01
+	assert.ErrorIs(t, decrypt(tampered), ErrGCMAuth)
+
+	// 篡改头部
+	tampered = append([]byte{}, ciphertext...)
+	tampered[8] ^= 0x01
+	assert.ErrorIs(t, decrypt(tampered), ErrGCMAuth)
+
+	// 交换分片顺序
+	tampered = append([]byte{}, ciphertext[:streamHeaderSize]...)
+	tampered = append(tampered, ciphertext[streamHeaderSize+segmentSize:streamHeaderSize+segmentSize*2]...)
+	tampered = append(tampered, ciphertext[streamHeaderSize:streamHeaderSize+segmentSize]...)
+	tampered = append(tampered, ciphertext[streamHeaderSize+segmentSize*2:]...)
+	assert.ErrorIs(t, decrypt(tampered), ErrGCMAuth)
+
+	// 头部不完整
+	assert.ErrorIs(t, decrypt(ciphertext[:5]), ErrStreamHeader)
+
+	// 密钥错误
+	r, err := NewAESDecryptReader([]byte("E15E2A40282E42E0"), bytes.NewReader(ciphertext))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrGCMAuth)
+}
+
+func TestAESStreamClosed(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewAESEncryptWriter([]byte("E15E2A40282E42E0"), &buf, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	_, err = w.Write([]byte("test crypt"))
+	assert.ErrorIs(t, err, ErrStreamClosed)
+	assert.Equal(t, streamHeaderSize+GCMTagSize, buf.Len())
+}
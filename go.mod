@@ -1,11 +1,12 @@
 module saas
 
-go 1.19
+go 1.20
 
 require (
 	github.com/smartystreets/goconvey v1.7.2
 	github.com/stretchr/testify v1.8.1
 	github.com/thoas/go-funk v0.9.2
+	golang.org/x/crypto v0.17.0
 )
 
 require (
@@ -14,5 +15,6 @@ require (
 	github.com/jtolds/gls v4.20.0+incompatible // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/smartystreets/assertions v1.2.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,6 +2,7 @@ package mtenant
 
 import (
 	"encoding/json"
+	"net/http"
 	"saas/oauth"
 	"saas/rbac"
 	"time"
@@ -33,11 +34,18 @@ func NewTenant(tenantID string, tenantName string, displayName string,
 		DisplayName:     displayName,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
-		oAuth:       oauth.NewOAuth(mdb, tdb),
+		oAuth:       newTenantOAuth(tenantID, mdb, tdb),
 		rbacMatrix:  rbac.NewRBACMatrix(),
 	}
 }
 
+// newTenantOAuth 生成属于租户的OAuth实例
+func newTenantOAuth(tenantID string, mdb oauth.MerchantDB, tdb oauth.TokenDB) *oauth.OAuth {
+	o := oauth.NewOAuth(mdb, tdb)
+	o.SetTenant(tenantID)
+	return o
+}
+
 // Prettify 格式化输出,便于调试
 func (t *Tenant) Prettify() string {
 	str, _ := json.MarshalIndent(t, "", "    ")
@@ -78,14 +86,20 @@ func (t *Tenant) HasMerchant(merchant *oauth.Merchant) bool {
 // ---------------------------------------------------
 // 以下是基于OAuth的身份鉴别
 
-// GetAccessToken 商户获取某个App对应的accesstoken
-func (t *Tenant) GetAccessToken(merchantID, appID, targetSign string) (string, error) {
-	return t.oAuth.GetAccessToken(&oauth.MerchantInfo{MerchantID: merchantID, AppID: appID}, targetSign)
+// GetAccessToken 商户获取某个App对应的accesstoken，timestamp与nonce为签名时使用的值，用于防重放
+func (t *Tenant) GetAccessToken(merchantID, appID string, timestamp int64, nonce, targetSign string) (string, error) {
+	mInfo := &oauth.MerchantInfo{MerchantID: merchantID, AppID: appID, Timestamp: timestamp, Nonce: nonce}
+	return t.oAuth.GetAccessToken(mInfo, targetSign)
 }
 
-// RefreshToken 商户对某个App对应的accesstoken进行续期操作
-func (t *Tenant) RefreshToken(merchantID, appID, accessToken string) (string, error) {
-	return t.oAuth.RefreshToken(&oauth.MerchantInfo{MerchantID: merchantID, AppID: appID}, accessToken)
+// IssueToken 商户获取某个App对应的accesstoken与refreshtoken，mInfo中可以指定申请的scope
+func (t *Tenant) IssueToken(mInfo *oauth.MerchantInfo, targetSign string) (*oauth.Token, error) {
+	return t.oAuth.IssueToken(mInfo, targetSign)
+}
+
+// RefreshToken 商户使用refreshtoken为某个App换取新的accesstoken与refreshtoken
+func (t *Tenant) RefreshToken(merchantID, appID, refreshToken string) (*oauth.Token, error) {
+	return t.oAuth.RefreshToken(&oauth.MerchantInfo{MerchantID: merchantID, AppID: appID}, refreshToken)
 }
 
 // VerifyToken 验证商户的某个App对应的accesstoken是否有效
@@ -93,11 +107,29 @@ func (t *Tenant) VerifyToken(merchantID, appID, accessToken string) error {
 	return t.oAuth.VerifyToken(&oauth.MerchantInfo{MerchantID: merchantID, AppID: appID}, accessToken)
 }
 
+// VerifyTokenScopes 验证accesstoken是否有效且具备required中的全部scope，返回授予的scope
+func (t *Tenant) VerifyTokenScopes(merchantID, appID, accessToken string, required ...string) (oauth.Scopes, error) {
+	return t.oAuth.VerifyTokenScopes(&oauth.MerchantInfo{MerchantID: merchantID, AppID: appID}, accessToken, required...)
+}
+
 // RevokeToken 将商户的某个App对应的accesstoken设置为无效
 func (t *Tenant) RevokeToken(merchantID, appID, accessToken string) error {
 	return t.oAuth.RevokeToken(&oauth.MerchantInfo{MerchantID: merchantID, AppID: appID}, accessToken)
 }
 
+// SetTokenPolicy 设置租户的Token策略，appID为空时为租户默认策略，否则覆盖该App自带的策略
+func (t *Tenant) SetTokenPolicy(appID string, policy *oauth.TokenPolicy) {
+	t.oAuth.SetTokenPolicy(appID, policy)
+}
+
+// Handler 返回租户的Token HTTP接口，见oauth.OAuth.Handler。
+// 多租户时按租户挂载到不同前缀，如：
+// mux.Handle("/tenants/{tenantID}/", http.StripPrefix("/tenants/{tenantID}", tenant.Handler()))
+// 商户对包含前缀的完整路径签名，验签时使用原始请求路径，签名不能重放到其他租户
+func (t *Tenant) Handler() http.Handler {
+	return t.oAuth.Handler()
+}
+
 // ---------------------------------------------------
 // 以下是基于RBAC的权限控制
 
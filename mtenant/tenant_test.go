@@ -1,8 +1,15 @@
 package mtenant
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"saas/crypt"
 	"saas/oauth"
 	"saas/rbac"
+	"strconv"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -55,8 +62,8 @@ func Test(t *testing.T) {
 
 	Convey("商户相关操作", t, func() {
 		merchant := oauth.NewMerchant("txsp", publicKey)
-		merchant.AddApp(&oauth.Application{AppID: "AppID1", AppSecret: "AppID1Secret", Scope: "AppID1Scope", AppName: "AppID1Name"})
-		merchant.AddApp(&oauth.Application{AppID: "AppID2", AppSecret: "AppID2Secret", Scope: "AppID2Scope", AppName: "AppID2Name"})
+		merchant.AddApp(&oauth.Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		merchant.AddApp(&oauth.Application{AppID: "AppID2", Scope: "AppID2Scope", AppName: "AppID2Name"})
 
 		err := tenant.AddMerchant(merchant)
 		So(err, ShouldBeNil)
@@ -73,21 +80,26 @@ func Test(t *testing.T) {
 
 	Convey("基于OAuth的身份鉴别", t, func() {
 		merchant := oauth.NewMerchant("txsp", publicKey)
-		merchant.AddApp(&oauth.Application{AppID: "AppID1", AppSecret: "AppID1Secret", Scope: "AppID1Scope", AppName: "AppID1Name"})
-		merchant.AddApp(&oauth.Application{AppID: "AppID2", AppSecret: "AppID2Secret", Scope: "AppID2Scope", AppName: "AppID2Name"})
+		merchant.AddApp(&oauth.Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		merchant.AddApp(&oauth.Application{AppID: "AppID2", Scope: "AppID2Scope", AppName: "AppID2Name"})
 		err := tenant.AddMerchant(merchant)
 		So(err, ShouldBeNil)
 
 		mInfo := &oauth.MerchantInfo{MerchantID: "txsp", AppID: "AppID1"}
 		targetSign, err := oauth.SignMerchantInfo(privateKey, mInfo)
 		So(err, ShouldBeNil)
-		accessToken, err := tenant.GetAccessToken("txsp", "AppID1", targetSign)
+		token, err := tenant.IssueToken(mInfo, targetSign)
 		So(err, ShouldBeNil)
+		accessToken := token.AccessToken
 		t.Log(accessToken)
 		err = tenant.VerifyToken("txsp", "AppID1", accessToken)
 		So(err, ShouldBeNil)
-		accessToken, err = tenant.RefreshToken("txsp", "AppID1", accessToken)
+		granted, err := tenant.VerifyTokenScopes("txsp", "AppID1", accessToken, "AppID1Scope")
 		So(err, ShouldBeNil)
+		So(granted, ShouldResemble, oauth.Scopes{"AppID1Scope"})
+		token, err = tenant.RefreshToken("txsp", "AppID1", token.RefreshToken)
+		So(err, ShouldBeNil)
+		accessToken = token.AccessToken
 		t.Log(accessToken)
 		err = tenant.RevokeToken("txsp", "AppID1", accessToken)
 		So(err, ShouldBeNil)
@@ -99,10 +111,83 @@ func Test(t *testing.T) {
 		So(ok, ShouldBeFalse)
 	})
 
+	Convey("按租户挂载HTTP接口", t, func() {
+		merchant := oauth.NewMerchant("txsp", publicKey)
+		merchant.AddApp(&oauth.Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		err := tenant.AddMerchant(merchant)
+		So(err, ShouldBeNil)
+		defer tenant.DelMerchant(merchant)
+
+		mux := http.NewServeMux()
+		prefix := "/tenants/" + tenant.TenantID
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, tenant.Handler()))
+
+		mInfo := &oauth.MerchantInfo{MerchantID: "txsp", AppID: "AppID1"}
+		targetSign, err := oauth.SignMerchantInfo(privateKey, mInfo)
+		So(err, ShouldBeNil)
+		form := url.Values{
+			"grant_type":  {oauth.GrantTypeMerchantSign},
+			"merchant_id": {mInfo.MerchantID},
+			"app_id":      {mInfo.AppID},
+			"timestamp":   {strconv.FormatInt(mInfo.Timestamp, 10)},
+			"nonce":       {mInfo.Nonce},
+			"sign":        {targetSign},
+		}
+		r := httptest.NewRequest(http.MethodPost, prefix+oauth.PathToken, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		resp := &oauth.TokenResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+		So(tenant.VerifyToken("txsp", "AppID1", resp.AccessToken), ShouldBeNil)
+
+		// 签名接口按包含前缀的完整路径验签
+		newIntrospect := func(path string) *http.Request {
+			form := url.Values{"merchant_id": {"txsp"}, "app_id": {"AppID1"}, "token": {resp.AccessToken}}
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		}
+		r = newIntrospect(prefix + oauth.PathIntrospect)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		result := &oauth.Introspection{}
+		So(json.Unmarshal(w.Body.Bytes(), result), ShouldBeNil)
+		So(result.Active, ShouldBeTrue)
+		So(result.Tenant, ShouldEqual, tenant.TenantID)
+
+		// 对去掉前缀的路径签名无效
+		r = newIntrospect(oauth.PathIntrospect)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		stripped := newIntrospect(prefix + oauth.PathIntrospect)
+		stripped.Header = r.Header
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, stripped)
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+
+		// 签名不能重放到使用相同商户公钥的其他租户
+		other := NewTenant("Alibaba", "阿里", "阿里", oauth.NewBackendMerchantDB(), oauth.NewBackendTokenDB())
+		otherMerchant := oauth.NewMerchant("txsp", publicKey)
+		otherMerchant.AddApp(&oauth.Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		So(other.AddMerchant(otherMerchant), ShouldBeNil)
+		otherPrefix := "/tenants/" + other.TenantID
+		mux.Handle(otherPrefix+"/", http.StripPrefix(otherPrefix, other.Handler()))
+		r = newIntrospect(prefix + oauth.PathIntrospect)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		replay := newIntrospect(otherPrefix + oauth.PathIntrospect)
+		replay.Header = r.Header
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, replay)
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+	})
+
 	Convey("基于RBAC的权限控制", t, func() {
 		merchant := oauth.NewMerchant("txsp", publicKey)
-		merchant.AddApp(&oauth.Application{AppID: "AppID1", AppSecret: "AppID1Secret", Scope: "AppID1Scope", AppName: "AppID1Name"})
-		merchant.AddApp(&oauth.Application{AppID: "AppID2", AppSecret: "AppID2Secret", Scope: "AppID2Scope", AppName: "AppID2Name"})
+		merchant.AddApp(&oauth.Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		merchant.AddApp(&oauth.Application{AppID: "AppID2", Scope: "AppID2Scope", AppName: "AppID2Name"})
 		err := tenant.AddMerchant(merchant)
 		So(err, ShouldBeNil)
 		// Operation
@@ -0,0 +1,246 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 授权码模式(RFC 6749 4.1)与PKCE(RFC 7636)相关常量
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	ResponseTypeCode           = "code"
+	// CodeChallengeS256 唯一支持的code_challenge_method，不支持plain
+	CodeChallengeS256 = "S256"
+
+	// AuthCodeExpiry 授权码有效期
+	AuthCodeExpiry = time.Minute
+)
+
+// ErrAccessDenied 商户拒绝授权
+var ErrAccessDenied = errors.New("access denied")
+
+// AuthorizationCode 授权码，绑定商户、App、回调地址与PKCE challenge
+type AuthorizationCode struct {
+	Code                string        `json:"-"` // 明文，只在签发时返回
+	MerchantID          string        `json:"merchant_id"`
+	AppID               string        `json:"app_id"`
+	RedirectURI         string        `json:"redirect_uri"`
+	Scope               string        `json:"scope"`
+	CodeChallenge       string        `json:"code_challenge"`
+	CodeChallengeMethod string        `json:"code_challenge_method"`
+	FamilyID            string        `json:"family_id,omitempty"` // 换取的Token家族
+	CreateAt            time.Time     `json:"create_at"`
+	ExpiresIn           time.Duration `json:"expires_in"`
+}
+
+// expired 判断授权码是否已过期
+func (c *AuthorizationCode) expired(now time.Time) bool {
+	return !c.CreateAt.Add(c.ExpiresIn).After(now)
+}
+
+// AuthorizeRequest 授权请求的参数
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string // AppID
+	RedirectURI         string
+	Scope               string // 校验后为收窄到App允许范围的scope
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizeError 授权请求的错误，Redirect为true时应携带错误重定向回客户端，
+// 否则客户端或回调地址不可信，只能直接向用户展示错误
+type AuthorizeError struct {
+	Code        string
+	Description string
+	Redirect    bool
+}
+
+// Error 实现error接口
+func (e *AuthorizeError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// Consent 商户对授权请求的同意结果
+type Consent struct {
+	MerchantID string // 同意授权的商户，必须拥有请求的App
+	Scope      string // 同意授予的scope，以空格分隔，为空时授予请求的全部scope，不能超出请求的范围
+}
+
+// ConsentFunc 授权同意回调，由业务方识别当前登录的商户并征得其同意。
+// 返回ErrAccessDenied表示商户拒绝授权；返回nil, nil表示回调已自行输出响应(如登录页、同意页)，
+// 商户操作后应携带原参数再次请求授权接口
+type ConsentFunc func(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) (*Consent, error)
+
+// SetCodeDB 设置存储授权码的数据库，默认为BackendCodeDB
+func (o *OAuth) SetCodeDB(cdb CodeDB) {
+	o.codeDB = cdb
+}
+
+// SetConsent 设置授权同意回调，未设置时授权接口不可用
+func (o *OAuth) SetConsent(consent ConsentFunc) {
+	o.consent = consent
+}
+
+// ParseAuthorizeRequest 解析并校验授权请求。先校验client_id与redirect_uri，
+// 二者不可信时返回的AuthorizeError不可重定向；redirect_uri必须提供且与App登记的地址完全一致。
+// 出错时也会返回已解析的AuthorizeRequest，便于携带state重定向
+func (o *OAuth) ParseAuthorizeRequest(params url.Values) (*AuthorizeRequest, error) {
+	req := &AuthorizeRequest{
+		ResponseType:        params.Get("response_type"),
+		ClientID:            params.Get("client_id"),
+		RedirectURI:         params.Get("redirect_uri"),
+		Scope:               params.Get("scope"),
+		State:               params.Get("state"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+	}
+	if err := checkParams(params, "client_id", "redirect_uri"); err != nil {
+		return req, &AuthorizeError{Code: ErrCodeInvalidRequest, Description: err.Error()}
+	}
+	merchant, err := o.merchantDB.ReadByApp(req.ClientID)
+	if err != nil {
+		return req, &AuthorizeError{Code: ErrCodeInvalidClient, Description: err.Error()}
+	}
+	app := merchant.GetApp(req.ClientID)
+	if !app.HasRedirectURI(req.RedirectURI) {
+		return req, &AuthorizeError{Code: ErrCodeInvalidRequest, Description: "redirect_uri not registered"}
+	}
+
+	if req.ResponseType != ResponseTypeCode {
+		return req, &AuthorizeError{Code: ErrCodeUnsupportedResponseType, Description: "response_type must be code", Redirect: true}
+	}
+	if req.CodeChallengeMethod != CodeChallengeS256 {
+		return req, &AuthorizeError{Code: ErrCodeInvalidRequest, Description: "code_challenge_method must be S256", Redirect: true}
+	}
+	// S256的challenge为SHA-256摘要的base64url编码，固定43个字符
+	if len(req.CodeChallenge) != 43 || !isBase64URL(req.CodeChallenge) {
+		return req, &AuthorizeError{Code: ErrCodeInvalidRequest, Description: "code_challenge invalid", Redirect: true}
+	}
+	scopes, err := NarrowScopes(ParseScopes(app.Scope), ParseScopes(req.Scope))
+	if err != nil {
+		return req, &AuthorizeError{Code: ErrCodeInvalidScope, Description: err.Error(), Redirect: true}
+	}
+	req.Scope = scopes.String()
+	return req, nil
+}
+
+// Authorize 商户同意授权后签发授权码，req需经过ParseAuthorizeRequest校验
+func (o *OAuth) Authorize(req *AuthorizeRequest, consent *Consent) (string, error) {
+	merchant, err := o.merchantDB.Read(consent.MerchantID)
+	if err != nil {
+		return "", err
+	}
+	if !merchant.HasApp(req.ClientID) {
+		return "", fmt.Errorf("merchant(%s) do not have app(%s)", consent.MerchantID, req.ClientID)
+	}
+	scopes, err := NarrowScopes(ParseScopes(req.Scope), ParseScopes(consent.Scope))
+	if err != nil {
+		return "", err
+	}
+	code, err := randomBase62(32)
+	if err != nil {
+		return "", err
+	}
+	err = o.codeDB.SaveCode(&AuthorizationCode{
+		Code:                code,
+		MerchantID:          consent.MerchantID,
+		AppID:               req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               scopes.String(),
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		CreateAt:            time.Now(),
+		ExpiresIn:           AuthCodeExpiry,
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeCode 使用授权码换取Token。授权码只能使用一次，且clientID、redirectURI必须与授权请求一致，
+// codeVerifier必须与code_challenge匹配；授权码被重用时吊销已换取的Token家族并返回ErrAuthCodeReused。
+// App登记了AppSecret时调用方需先完成客户端认证，见TokenHandler
+func (o *OAuth) ExchangeCode(clientID, code, redirectURI, codeVerifier string) (*Token, error) {
+	record, err := o.codeDB.ConsumeCode(code)
+	if err == ErrAuthCodeReused {
+		if record.FamilyID != "" {
+			o.revokeFamily(record.FamilyID, o.appTokenPolicy(record.MerchantID, record.AppID))
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.expired(time.Now()) {
+		return nil, errors.New("authorization code expires")
+	}
+	if record.AppID != clientID {
+		return nil, fmt.Errorf("authorization code not belong to app(%s)", clientID)
+	}
+	if record.RedirectURI != redirectURI {
+		return nil, errors.New("redirect_uri mismatch")
+	}
+	if !verifyCodeChallenge(record.CodeChallenge, codeVerifier) {
+		return nil, errors.New("code_verifier mismatch")
+	}
+	merchant, err := o.merchantDB.Read(record.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	if !merchant.HasApp(record.AppID) {
+		return nil, fmt.Errorf("merchant(%s) do not have app(%s)", record.MerchantID, record.AppID)
+	}
+	policy := o.tokenPolicy(merchant.GetApp(record.AppID))
+	token, err := o.tokenDB.CreateToken(record.MerchantID, record.AppID, record.Scope, policy)
+	if err != nil {
+		return nil, err
+	}
+	if err = o.codeDB.BindFamily(code, token.GetFamilyID()); err != nil {
+		return nil, err
+	}
+	return o.withJWT(&MerchantInfo{MerchantID: record.MerchantID, AppID: record.AppID}, token, policy)
+}
+
+// CodeChallenge 由code_verifier计算S256方式的code_challenge，供客户端使用
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyCodeChallenge 校验code_verifier，verifier为43到128个unreserved字符(RFC 7636 4.1)
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		if !isUnreserved(c) {
+			return false
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// isUnreserved 判断是否为RFC 3986的unreserved字符
+func isUnreserved(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// isBase64URL 判断是否为不带填充的base64url字符串
+func isBase64URL(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const (
+	testRedirectURI  = "https://tool.example.com/callback?tenant=1"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r7wW1gFWFOEjXk"
+)
+
+// newAuthCodeOAuth 生成为AppID3登记了回调地址的OAuth实例，AppID3为未登记AppSecret的公开客户端
+func newAuthCodeOAuth() *OAuth {
+	o := newTestOAuth()
+	merchant, _ := o.MerchantDB().Read("Tencent")
+	merchant.GetApp("AppID1").RedirectURIs = []string{testRedirectURI}
+	merchant.AddApp(&Application{AppID: "AppID3", Scope: "orders", AppName: "AppID3Name",
+		RedirectURIs: []string{testRedirectURI}})
+	return o
+}
+
+// authorizeParams 生成授权请求参数
+func authorizeParams(appID string) url.Values {
+	return url.Values{
+		"response_type":         {ResponseTypeCode},
+		"client_id":             {appID},
+		"redirect_uri":          {testRedirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {CodeChallenge(testCodeVerifier)},
+		"code_challenge_method": {CodeChallengeS256},
+	}
+}
+
+func TestAuthorizationCode(t *testing.T) {
+
+	Convey("PKCE", t, func() {
+		So(CodeChallenge(testCodeVerifier), ShouldHaveLength, 43)
+		So(verifyCodeChallenge(CodeChallenge(testCodeVerifier), testCodeVerifier), ShouldBeTrue)
+		So(verifyCodeChallenge(CodeChallenge(testCodeVerifier), testCodeVerifier[1:]), ShouldBeFalse)
+		So(verifyCodeChallenge(CodeChallenge("short"), "short"), ShouldBeFalse)
+	})
+
+	Convey("授权请求校验", t, func() {
+		o := newAuthCodeOAuth()
+		req, err := o.ParseAuthorizeRequest(authorizeParams("AppID1"))
+		So(err, ShouldBeNil)
+		So(req.Scope, ShouldEqual, "orders users:read")
+
+		cases := []struct {
+			key, value string
+			code       string
+			redirect   bool
+		}{
+			{"client_id", "AppIDX", ErrCodeInvalidClient, false},
+			{"redirect_uri", "https://evil.example.com/callback", ErrCodeInvalidRequest, false},
+			{"response_type", "token", ErrCodeUnsupportedResponseType, true},
+			{"code_challenge_method", "plain", ErrCodeInvalidRequest, true},
+			{"code_challenge", "abc", ErrCodeInvalidRequest, true},
+			{"scope", "users:write", ErrCodeInvalidScope, true},
+		}
+		for _, c := range cases {
+			params := authorizeParams("AppID1")
+			params.Set(c.key, c.value)
+			req, err = o.ParseAuthorizeRequest(params)
+			authErr := &AuthorizeError{}
+			So(errors.As(err, &authErr), ShouldBeTrue)
+			So(authErr.Code, ShouldEqual, c.code)
+			So(authErr.Redirect, ShouldEqual, c.redirect)
+			So(req.State, ShouldEqual, "xyz")
+		}
+	})
+
+	Convey("授权码换取Token", t, func() {
+		o := newAuthCodeOAuth()
+		req, err := o.ParseAuthorizeRequest(authorizeParams("AppID1"))
+		So(err, ShouldBeNil)
+
+		_, err = o.Authorize(req, &Consent{MerchantID: "Tencent", Scope: "users:write"})
+		So(errors.Is(err, ErrInvalidScope), ShouldBeTrue)
+		_, err = o.Authorize(req, &Consent{MerchantID: "Alibaba"})
+		So(err, ShouldBeError)
+
+		code, err := o.Authorize(req, &Consent{MerchantID: "Tencent", Scope: "users:read"})
+		So(err, ShouldBeNil)
+		_, err = o.ExchangeCode("AppID1", code, testRedirectURI, strings.Repeat("a", 43))
+		So(err, ShouldBeError)
+		// 校验失败后授权码同样失效
+		_, err = o.ExchangeCode("AppID1", code, testRedirectURI, testCodeVerifier)
+		So(err, ShouldEqual, ErrAuthCodeReused)
+
+		code, err = o.Authorize(req, &Consent{MerchantID: "Tencent", Scope: "users:read"})
+		So(err, ShouldBeNil)
+		token, err := o.ExchangeCode("AppID1", code, testRedirectURI, testCodeVerifier)
+		So(err, ShouldBeNil)
+		So(token.GetScope(), ShouldEqual, "users:read")
+		So(token.GetMerchantID(), ShouldEqual, "Tencent")
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+		So(o.VerifyToken(mInfo, token.AccessToken), ShouldBeNil)
+
+		// 重用授权码吊销已换取的Token
+		_, err = o.ExchangeCode("AppID1", code, testRedirectURI, testCodeVerifier)
+		So(err, ShouldEqual, ErrAuthCodeReused)
+		So(o.VerifyToken(mInfo, token.AccessToken), ShouldBeError)
+
+		mismatches := []struct{ clientID, redirectURI string }{
+			{"AppID2", testRedirectURI},
+			{"AppID1", "https://tool.example.com/callback"},
+		}
+		for _, m := range mismatches {
+			code, err = o.Authorize(req, &Consent{MerchantID: "Tencent"})
+			So(err, ShouldBeNil)
+			_, err = o.ExchangeCode(m.clientID, code, m.redirectURI, testCodeVerifier)
+			So(err, ShouldBeError)
+		}
+		_, err = o.ExchangeCode("AppID1", "unknown", testRedirectURI, testCodeVerifier)
+		So(err, ShouldBeError)
+	})
+
+	Convey("授权码过期", t, func() {
+		cdb := NewBackendCodeDB()
+		err := cdb.SaveCode(&AuthorizationCode{Code: "expired", AppID: "AppID1",
+			CreateAt: time.Now().Add(-2 * AuthCodeExpiry), ExpiresIn: AuthCodeExpiry})
+		So(err, ShouldBeNil)
+		o := newAuthCodeOAuth()
+		o.SetCodeDB(cdb)
+		_, err = o.ExchangeCode("AppID1", "expired", "", testCodeVerifier)
+		So(err, ShouldBeError)
+	})
+}
+
+func TestAuthorizeHandler(t *testing.T) {
+
+	Convey("授权码模式HTTP接口", t, func() {
+		o := newAuthCodeOAuth()
+		h := o.Handler()
+		target := PathAuthorize + "?" + authorizeParams("AppID3").Encode()
+
+		w := serve(h, httptest.NewRequest(http.MethodGet, target, nil))
+		So(w.Code, ShouldEqual, http.StatusNotFound)
+
+		// 通过请求头模拟已登录的商户，未登录时输出登录页
+		o.SetConsent(func(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) (*Consent, error) {
+			switch r.Header.Get("X-Merchant") {
+			case "":
+				w.Write([]byte("login"))
+				return nil, nil
+			case "deny":
+				return nil, ErrAccessDenied
+			}
+			return &Consent{MerchantID: r.Header.Get("X-Merchant")}, nil
+		})
+
+		w = serve(h, httptest.NewRequest(http.MethodGet, target, nil))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "login")
+
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("X-Merchant", "deny")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusFound)
+		location, err := url.Parse(w.Header().Get("Location"))
+		So(err, ShouldBeNil)
+		So(location.Query().Get("error"), ShouldEqual, ErrCodeAccessDenied)
+		So(location.Query().Get("state"), ShouldEqual, "xyz")
+		So(location.Query().Get("tenant"), ShouldEqual, "1")
+
+		// 回调地址未登记时不重定向
+		params := authorizeParams("AppID3")
+		params.Set("redirect_uri", "https://evil.example.com/callback")
+		w = serve(h, httptest.NewRequest(http.MethodGet, PathAuthorize+"?"+params.Encode(), nil))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		r = newFormRequest(PathAuthorize, authorizeParams("AppID3"))
+		r.Header.Set("X-Merchant", "Tencent")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusFound)
+		location, err = url.Parse(w.Header().Get("Location"))
+		So(err, ShouldBeNil)
+		So(location.Host, ShouldEqual, "tool.example.com")
+		So(location.Query().Get("state"), ShouldEqual, "xyz")
+		code := location.Query().Get("code")
+		So(code, ShouldNotBeEmpty)
+
+		// 公开客户端只需提供client_id
+		form := url.Values{
+			"grant_type":    {GrantTypeAuthorizationCode},
+			"client_id":     {"AppID3"},
+			"code":          {code},
+			"redirect_uri":  {testRedirectURI},
+			"code_verifier": {testCodeVerifier},
+		}
+		w = serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		resp := &TokenResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+		So(resp.Scope, ShouldEqual, "orders")
+		So(resp.RefreshToken, ShouldNotBeEmpty)
+
+		// 公开客户端刷新Token
+		w = serve(h, newFormRequest(PathToken, url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"client_id":     {"AppID3"},
+			"refresh_token": {resp.RefreshToken},
+		}))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+
+		// 授权码重用，刷新得到的Token同属一个家族，一并吊销
+		w = serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidGrant)
+		So(o.VerifyToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID3"}, resp.AccessToken), ShouldBeError)
+	})
+
+	Convey("登记了AppSecret的App需要客户端认证", t, func() {
+		o := newAuthCodeOAuth()
+		o.SetConsent(func(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) (*Consent, error) {
+			return &Consent{MerchantID: "Tencent"}, nil
+		})
+		h := o.Handler()
+		req, err := o.ParseAuthorizeRequest(authorizeParams("AppID1"))
+		So(err, ShouldBeNil)
+		code, err := o.Authorize(req, &Consent{MerchantID: "Tencent"})
+		So(err, ShouldBeNil)
+		form := url.Values{
+			"grant_type":    {GrantTypeAuthorizationCode},
+			"client_id":     {"AppID1"},
+			"code":          {code},
+			"redirect_uri":  {testRedirectURI},
+			"code_verifier": {testCodeVerifier},
+		}
+		w := serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidClient)
+
+		r := newFormRequest(PathToken, form)
+		r.SetBasicAuth("AppID1", "AppID1Secret")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+
+		// client_id与认证的客户端不一致
+		code, err = o.Authorize(req, &Consent{MerchantID: "Tencent"})
+		So(err, ShouldBeNil)
+		form.Set("code", code)
+		r = newFormRequest(PathToken, form)
+		r.SetBasicAuth("AppID2", "AppID2Secret")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidClient)
+	})
+}
@@ -0,0 +1,253 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"saas/crypt"
+	"strings"
+	"time"
+)
+
+// 客户端凭证授权(RFC 6749 4.4)与private_key_jwt客户端断言(RFC 7523)相关常量
+const (
+	GrantTypeClientCredentials = "client_credentials"
+	ClientAssertionTypeJWT     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// ClientAssertionExpiry SignClientAssertion生成的断言有效期
+	ClientAssertionExpiry = time.Minute * 5
+	// maxAssertionLifetime 断言的exp距当前时间的上限，同时限制jti的保留时间
+	maxAssertionLifetime = time.Hour
+)
+
+// ErrInvalidClient 客户端认证失败，不区分App不存在与密钥错误
+var ErrInvalidClient = errors.New("client authentication failed")
+
+// ClientAssertionClaims private_key_jwt客户端断言中的声明，iss与sub均为AppID
+type ClientAssertionClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ID        string   `json:"jti"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// audience JWT的aud声明，可以是字符串或字符串数组
+type audience []string
+
+// UnmarshalJSON 兼容字符串与字符串数组两种形式
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+// MarshalJSON 只有一个值时输出为字符串
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// contains 判断aud是否包含target
+func (a audience) contains(target string) bool {
+	for _, aud := range a {
+		if aud == target {
+			return true
+		}
+	}
+	return false
+}
+
+// SignClientAssertion 商户使用私钥为App生成private_key_jwt客户端断言，aud为授权服务配置的受众，
+// 通常为/token接口的完整URL。支持RSA、ECDSA、Ed25519私钥
+func SignClientAssertion(privateKey, appID, aud string) (string, error) {
+	key, err := crypt.LoadAnyPrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", err
+	}
+	jti, err := crypt.RandomNonce()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(&ClientAssertionClaims{
+		Issuer:    appID,
+		Subject:   appID,
+		Audience:  audience{aud},
+		ID:        jti,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ClientAssertionExpiry).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return crypt.SignJWS(key, "", payload)
+}
+
+// SetAssertionAudience 设置private_key_jwt客户端断言要求的aud，通常为/token接口的完整URL，
+// 未设置时拒绝所有客户端断言
+func (o *OAuth) SetAssertionAudience(aud string) {
+	o.assertAud = aud
+}
+
+// ClientCredentialsToken 使用AppID与AppSecret认证并签发Token，scope为空时授予App允许的全部scope。
+// 认证失败时返回ErrInvalidClient；按RFC 6749 4.4.3不返回refreshtoken，过期后重新认证即可
+func (o *OAuth) ClientCredentialsToken(appID, appSecret, scope string) (*Token, error) {
+	merchant, err := o.AuthenticateClientSecret(appID, appSecret)
+	if err != nil {
+		return nil, err
+	}
+	return o.issueClientToken(merchant, appID, scope)
+}
+
+// ClientAssertionToken 使用private_key_jwt客户端断言认证并签发Token，
+// clientID可以为空，不为空时必须与断言的iss一致
+func (o *OAuth) ClientAssertionToken(clientID, assertion, scope string) (*Token, error) {
+	merchant, appID, err := o.AuthenticateClientAssertion(clientID, assertion)
+	if err != nil {
+		return nil, err
+	}
+	return o.issueClientToken(merchant, appID, scope)
+}
+
+// issueClientToken 为客户端凭证授权签发不含refreshtoken的Token
+func (o *OAuth) issueClientToken(merchant *Merchant, appID, scope string) (*Token, error) {
+	token, err := o.issue(merchant, &MerchantInfo{MerchantID: merchant.MerchantID, AppID: appID, Scope: scope})
+	if err != nil {
+		return nil, err
+	}
+	token.SetRefreshToken("")
+	return token, nil
+}
+
+// AuthenticateClientSecret 使用AppID与AppSecret认证客户端，返回App所属商户。
+// 未设置AppSecret的App不允许使用密钥认证；AppSecret只以哈希保存，见Application.SetAppSecret
+func (o *OAuth) AuthenticateClientSecret(appID, appSecret string) (*Merchant, error) {
+	merchant, err := o.merchantDB.ReadByApp(appID)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	app := merchant.GetApp(appID)
+	if app == nil || app.VerifyAppSecret(appSecret) != nil {
+		return nil, ErrInvalidClient
+	}
+	return merchant, nil
+}
+
+// authenticatePublicClient 认证公开客户端，只有未登记AppSecret的App可以不提供凭证
+func (o *OAuth) authenticatePublicClient(appID string) (*Merchant, error) {
+	merchant, err := o.merchantDB.ReadByApp(appID)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	if app := merchant.GetApp(appID); app == nil || app.AppSecretHash != "" {
+		return nil, ErrInvalidClient
+	}
+	return merchant, nil
+}
+
+// AuthenticateClientAssertion 校验private_key_jwt客户端断言，返回App所属商户与AppID。
+// 断言需由商户私钥签名，iss与sub为AppID，aud包含SetAssertionAudience设置的值，
+// exp在允许的时钟偏差内未过期且不超过一小时，jti在有效期内不可重复使用
+func (o *OAuth) AuthenticateClientAssertion(clientID, assertion string) (*Merchant, string, error) {
+	if o.assertAud == "" {
+		return nil, "", fmt.Errorf("%w: client assertion audience not configured", ErrInvalidClient)
+	}
+	unverified, err := parseAssertion(assertion)
+	if err != nil {
+		return nil, "", err
+	}
+	appID := unverified.Issuer
+	if appID == "" || (clientID != "" && clientID != appID) {
+		return nil, "", fmt.Errorf("%w: client assertion iss mismatch", ErrInvalidClient)
+	}
+	merchant, err := o.merchantDB.ReadByApp(appID)
+	if err != nil {
+		return nil, "", ErrInvalidClient
+	}
+	pub, err := crypt.LoadAnyPublicKey([]byte(merchant.GetKey()))
+	if err != nil {
+		return nil, "", err
+	}
+	_, payload, err := crypt.VerifyJWS(pub, assertion)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidClient, err)
+	}
+	claims := &ClientAssertionClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, "", fmt.Errorf("%w: client assertion invalid", ErrInvalidClient)
+	}
+	if claims.Issuer != appID || claims.Subject != appID {
+		return nil, "", fmt.Errorf("%w: client assertion sub must equal iss", ErrInvalidClient)
+	}
+	if !claims.Audience.contains(o.assertAud) {
+		return nil, "", fmt.Errorf("%w: client assertion aud mismatch", ErrInvalidClient)
+	}
+	if claims.ID == "" {
+		return nil, "", fmt.Errorf("%w: client assertion jti missing", ErrInvalidClient)
+	}
+	if err = o.checkAssertionTime(appID, claims); err != nil {
+		return nil, "", err
+	}
+	return merchant, appID, nil
+}
+
+// checkAssertionTime 校验断言的有效期，并记录jti防止重放
+func (o *OAuth) checkAssertionTime(appID string, claims *ClientAssertionClaims) error {
+	maxSkew := o.reqVerify.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = crypt.DefaultMaxSkew
+	}
+	now := time.Now()
+	if o.reqVerify.Now != nil {
+		now = o.reqVerify.Now()
+	}
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if claims.ExpiresAt == 0 || now.Sub(expiresAt) > maxSkew {
+		return fmt.Errorf("%w: client assertion expired", ErrInvalidClient)
+	}
+	if expiresAt.Sub(now) > maxAssertionLifetime {
+		return fmt.Errorf("%w: client assertion lifetime too long", ErrInvalidClient)
+	}
+	if claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).Sub(now) > maxSkew {
+		return fmt.Errorf("%w: client assertion issued in the future", ErrInvalidClient)
+	}
+	if o.reqVerify.Nonces == nil {
+		return nil
+	}
+	// jti保留到断言在时钟偏差内彻底失效
+	err := o.reqVerify.Nonces.Use("assertion:"+appID+":"+claims.ID, expiresAt.Sub(now)+maxSkew)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidClient, err)
+	}
+	return nil
+}
+
+// parseAssertion 不验签解析断言中的声明，仅用于确定验签使用的商户公钥
+func parseAssertion(assertion string) (*ClientAssertionClaims, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: client assertion malformed", ErrInvalidClient)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: client assertion malformed", ErrInvalidClient)
+	}
+	claims := &ClientAssertionClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: client assertion malformed", ErrInvalidClient)
+	}
+	return claims, nil
+}
@@ -0,0 +1,114 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"saas/crypt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// signTestAssertion 使用商户私钥对任意声明签名
+func signTestAssertion(claims *ClientAssertionClaims) (string, error) {
+	key, err := crypt.LoadAnyPrivateKey([]byte(privateKey))
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return crypt.SignJWS(key, "", payload)
+}
+
+func TestClientCredentials(t *testing.T) {
+
+	Convey("AppID与AppSecret认证", t, func() {
+		o := newTestOAuth()
+		token, err := o.ClientCredentialsToken("AppID1", "AppID1Secret", "users:read")
+		So(err, ShouldBeNil)
+		So(token.GetScope(), ShouldEqual, "users:read")
+		So(token.GetRefreshToken(), ShouldBeEmpty)
+		So(o.VerifyToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}, token.AccessToken), ShouldBeNil)
+
+		_, err = o.ClientCredentialsToken("AppID1", "AppID2Secret", "")
+		So(err, ShouldEqual, ErrInvalidClient)
+		_, err = o.ClientCredentialsToken("AppID3", "AppID1Secret", "")
+		So(err, ShouldEqual, ErrInvalidClient)
+		_, err = o.ClientCredentialsToken("AppID2", "AppID2Secret", "users:read")
+		So(errors.Is(err, ErrInvalidScope), ShouldBeTrue)
+
+		merchant, err := o.MerchantDB().ReadByApp("AppID2")
+		So(err, ShouldBeNil)
+		merchant.GetApp("AppID2").AppSecretHash = ""
+		_, err = o.ClientCredentialsToken("AppID2", "", "")
+		So(err, ShouldEqual, ErrInvalidClient)
+	})
+
+	Convey("private_key_jwt客户端断言", t, func() {
+		o := newTestOAuth()
+		aud := "https://auth.example.com/token"
+		assertion, err := SignClientAssertion(privateKey, "AppID1", aud)
+		So(err, ShouldBeNil)
+
+		// 未配置aud时拒绝
+		_, err = o.ClientAssertionToken("", assertion, "")
+		So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+
+		o.SetAssertionAudience(aud)
+		_, err = o.ClientAssertionToken("AppID2", assertion, "")
+		So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+		token, err := o.ClientAssertionToken("AppID1", assertion, "")
+		So(err, ShouldBeNil)
+		So(token.GetScope(), ShouldEqual, "orders users:read")
+		So(token.GetRefreshToken(), ShouldBeEmpty)
+
+		// jti不可重复使用
+		_, err = o.ClientAssertionToken("", assertion, "")
+		So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+
+		// aud不匹配
+		other, err := SignClientAssertion(privateKey, "AppID1", "https://other.example.com/token")
+		So(err, ShouldBeNil)
+		_, err = o.ClientAssertionToken("", other, "")
+		So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+
+		// 非商户私钥签名
+		otherKey, _, err := crypt.GenerateEd25519KeyStr()
+		So(err, ShouldBeNil)
+		other, err = SignClientAssertion(otherKey, "AppID1", aud)
+		So(err, ShouldBeNil)
+		_, err = o.ClientAssertionToken("", other, "")
+		So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+
+		now := time.Now()
+		invalid := []*ClientAssertionClaims{
+			// 已过期
+			{Issuer: "AppID1", Subject: "AppID1", Audience: audience{aud}, ID: "jti1", ExpiresAt: now.Add(-time.Hour).Unix()},
+			// 有效期过长
+			{Issuer: "AppID1", Subject: "AppID1", Audience: audience{aud}, ID: "jti2", ExpiresAt: now.Add(2 * time.Hour).Unix()},
+			// sub与iss不一致
+			{Issuer: "AppID1", Subject: "AppID2", Audience: audience{aud}, ID: "jti3", ExpiresAt: now.Add(time.Minute).Unix()},
+			// 缺少jti
+			{Issuer: "AppID1", Subject: "AppID1", Audience: audience{aud}, ExpiresAt: now.Add(time.Minute).Unix()},
+		}
+		for _, claims := range invalid {
+			other, err = signTestAssertion(claims)
+			So(err, ShouldBeNil)
+			_, err = o.ClientAssertionToken("", other, "")
+			So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+		}
+
+		// aud为数组
+		other, err = signTestAssertion(&ClientAssertionClaims{Issuer: "AppID1", Subject: "AppID1",
+			Audience: audience{"https://other.example.com", aud}, ID: "jti4", ExpiresAt: now.Add(time.Minute).Unix()})
+		So(err, ShouldBeNil)
+		_, err = o.ClientAssertionToken("", other, "orders")
+		So(err, ShouldBeNil)
+
+		_, err = o.ClientAssertionToken("", "not.a.jwt", "")
+		So(errors.Is(err, ErrInvalidClient), ShouldBeTrue)
+	})
+}
@@ -1,49 +1,81 @@
 package oauth
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"math/rand"
-	"strings"
+	"saas/crypt"
+	"sync"
 	"time"
-
-	"github.com/thoas/go-funk"
 )
 
 const (
-	// 大小票过期时间
+	// 默认的大小票过期时间，可由TokenPolicy覆盖
 	TokenExpiry   = time.Minute * 10
 	RefreshExpiry = time.Hour * 24 * 14
 )
 
+var (
+	// ErrRefreshTokenReused 已轮换的refreshtoken被再次使用，疑似泄露，整个Token家族已吊销
+	ErrRefreshTokenReused = errors.New("refresh token reused, token family revoked")
+	// ErrAuthCodeReused 授权码被再次使用，疑似泄露，由其换取的Token家族已吊销
+	ErrAuthCodeReused = errors.New("authorization code reused, token family revoked")
+	// ErrTokenNotFound Token不存在。错误中不包含Token的哈希，避免调用方借此反推哈希密钥
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenExpired Token已过期
+	ErrTokenExpired = errors.New("token expired")
+)
+
 // MerchantDB 存储商户的数据库
 type MerchantDB interface {
 	Read(merchantID string) (*Merchant, error)
+	// ReadByApp 通过AppID获取所属商户。AppID在同一个MerchantDB中唯一，由Create与Update保证
+	ReadByApp(appID string) (*Merchant, error)
 	Delete(merchantID string) error
 	Create(merchant *Merchant) error
 	Update(merchant *Merchant) error
 }
 
-// TokenDB 存储Token的数据库
+// TokenDB 存储Token的数据库。
+// 实现时不应持久化Token明文，应以TokenHasher计算的哈希为索引，按请求中的Token计算哈希后查找
 type TokenDB interface {
-	CreateToken(appID string, appSecret string) (*Token, error)
+	// CreateToken 开始一个新的Token家族，有效期由policy决定，policy为nil时使用默认值
+	CreateToken(merchantID string, appID string, scope string, policy *TokenPolicy) (*Token, error)
 	DeleteToken(accessToken string) error
 	GetToken(accessToken string) (*Token, error)
+	GetTokenByRefresh(refreshToken string) (*Token, error)
+	RevokeFamily(familyID string) error
 	VerifyToken(accessToken string) error
-	RefreshToken(accessToken string) (string, error)
+	// RefreshToken 使用refreshtoken换取新的Token，同时轮换refreshtoken；
+	// 已轮换的refreshtoken被再次使用时吊销整个Token家族，返回只含FamilyID的Token与ErrRefreshTokenReused；
+	// refreshtoken不属于该商户App时返回错误且不轮换；需按policy.CheckRefresh校验刷新次数、会话生存期与空闲超时
+	RefreshToken(merchantID string, appID string, refreshToken string, policy *TokenPolicy) (*Token, error)
 }
 
-// BackendMerchantDB 实现MerchantDB接口的后端数据库，仅示意
+// CodeDB 存储授权码的数据库。与TokenDB一样不应持久化授权码明文。
+// 授权码只能使用一次：ConsumeCode取出后即失效，过期前再次使用时返回原记录与ErrAuthCodeReused
+type CodeDB interface {
+	SaveCode(code *AuthorizationCode) error
+	ConsumeCode(code string) (*AuthorizationCode, error)
+	// BindFamily 记录授权码换取的Token家族，授权码被重用时据此吊销
+	BindFamily(code string, familyID string) error
+}
+
+// BackendMerchantDB 实现MerchantDB接口的后端数据库，仅示意。方法可以并发调用
 type BackendMerchantDB struct {
+	sync.Mutex
 	merchantStore map[string]*Merchant // key:merchantID, value:*Merchant
 }
 
 // NewBackendMerchantDB 生成BackendMerchantDB实例
 func NewBackendMerchantDB() *BackendMerchantDB {
-	return &BackendMerchantDB{map[string]*Merchant{}}
+	return &BackendMerchantDB{merchantStore: map[string]*Merchant{}}
 }
 
 // Read 通过merchantID从DB获取Merchant实例
 func (mdb *BackendMerchantDB) Read(merchantID string) (*Merchant, error) {
+	mdb.Lock()
+	defer mdb.Unlock()
 	m, ok := mdb.merchantStore[merchantID]
 	if !ok {
 		return nil, fmt.Errorf("this merchant not exist:%s", merchantID)
@@ -51,8 +83,22 @@ func (mdb *BackendMerchantDB) Read(merchantID string) (*Merchant, error) {
 	return m, nil
 }
 
+// ReadByApp 通过AppID从DB获取所属的Merchant实例
+func (mdb *BackendMerchantDB) ReadByApp(appID string) (*Merchant, error) {
+	mdb.Lock()
+	defer mdb.Unlock()
+	for _, m := range mdb.merchantStore {
+		if m.HasApp(appID) {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("this app not exist:%s", appID)
+}
+
 // Delete 通过merchantID从DB删除Merchant实例
 func (mdb *BackendMerchantDB) Delete(merchantID string) error {
+	mdb.Lock()
+	defer mdb.Unlock()
 	if _, ok := mdb.merchantStore[merchantID]; !ok {
 		return fmt.Errorf("this merchant not exist:%s", merchantID)
 	}
@@ -60,118 +106,364 @@ func (mdb *BackendMerchantDB) Delete(merchantID string) error {
 	return nil
 }
 
-// Create 将Merchant实例增加到DB
+// Create 将Merchant实例增加到DB，AppID已属于其他商户时返回错误
 func (mdb *BackendMerchantDB) Create(merchant *Merchant) error {
+	mdb.Lock()
+	defer mdb.Unlock()
 	if _, ok := mdb.merchantStore[merchant.MerchantID]; ok {
 		return fmt.Errorf("this merchant already exist:%s", merchant.MerchantID)
 	}
+	if err := mdb.checkApps(merchant); err != nil {
+		return err
+	}
 	mdb.merchantStore[merchant.MerchantID] = merchant
 	return nil
 }
 
-// Update 将Merchant实例更新到DB
+// Update 将Merchant实例更新到DB，AppID已属于其他商户时返回错误
 func (mdb *BackendMerchantDB) Update(merchant *Merchant) error {
+	mdb.Lock()
+	defer mdb.Unlock()
 	if _, ok := mdb.merchantStore[merchant.MerchantID]; !ok {
 		return fmt.Errorf("this merchant not exist:%s", merchant.MerchantID)
 	}
+	if err := mdb.checkApps(merchant); err != nil {
+		return err
+	}
 	mdb.merchantStore[merchant.MerchantID] = merchant
 	return nil
 }
 
-// BackendTokenDB 实现TokenDB接口的后端数据库，仅示意
+// checkApps 检查商户的AppID是否已属于其他商户，保证ReadByApp的结果唯一，调用方需持有锁
+func (mdb *BackendMerchantDB) checkApps(merchant *Merchant) error {
+	for appID := range merchant.Apps {
+		for _, m := range mdb.merchantStore {
+			if m.MerchantID != merchant.MerchantID && m.HasApp(appID) {
+				return fmt.Errorf("this app already belong to merchant(%s):%s", m.MerchantID, appID)
+			}
+		}
+	}
+	return nil
+}
+
+// BackendTokenDB 实现TokenDB接口的后端数据库，仅示意。方法可以并发调用
 type BackendTokenDB struct {
-	tokenStore map[string]*Token // key:accessToken, value:*Token
+	sync.Mutex
+	tokenStore   map[string]*Token       // key:accessToken的哈希, value:*Token，不含Token明文
+	refreshIndex map[string]string       // key:refreshToken的哈希, value:accessToken的哈希
+	usedRefresh  map[string]*usedRefresh // key:已轮换的refreshToken的哈希
+	generator    *TokenGenerator
+	hasher       *TokenHasher
+}
+
+// usedRefresh 已轮换的refreshtoken，保留到其原本的过期时间，用于检测重用
+type usedRefresh struct {
+	familyID  string
+	expiresAt time.Time
 }
 
-// NewBackendTokenDB 生成BackendTokenDB实例
+// NewBackendTokenDB 生成BackendTokenDB实例，哈希密钥为随机生成，仅在进程内有效，
+// 需要重启后仍能查找已保存的Token时通过SetTokenHasher设置固定密钥。随机数生成失败时panic
 func NewBackendTokenDB() *BackendTokenDB {
-	return &BackendTokenDB{map[string]*Token{}}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &BackendTokenDB{
+		tokenStore:   map[string]*Token{},
+		refreshIndex: map[string]string{},
+		usedRefresh:  map[string]*usedRefresh{},
+		generator:    NewTokenGenerator(),
+		hasher:       &TokenHasher{key: key},
+	}
+}
+
+// SetTokenGenerator 设置Token生成器，如按租户设置Tenant前缀
+func (tdb *BackendTokenDB) SetTokenGenerator(generator *TokenGenerator) {
+	tdb.Lock()
+	defer tdb.Unlock()
+	tdb.generator = generator
+}
+
+// SetTokenHasher 设置Token哈希，持久化存储需要使用固定的哈希密钥或NewTokenHasherWithKeyManager
+func (tdb *BackendTokenDB) SetTokenHasher(hasher *TokenHasher) {
+	tdb.Lock()
+	defer tdb.Unlock()
+	tdb.hasher = hasher
 }
 
-// CreateToken 创建Token实例
-func (tdb *BackendTokenDB) CreateToken(appID string, appSecret string) (*Token, error) {
+// CreateToken 创建Token实例，开始一个新的Token家族。返回的Token包含明文，DB中只保存哈希
+func (tdb *BackendTokenDB) CreateToken(merchantID string, appID string, scope string, policy *TokenPolicy) (*Token, error) {
+	familyID, err := randomBase62(22)
+	if err != nil {
+		return nil, err
+	}
 	token := &Token{
-		AppID:            appID,
-		AppSecret:        appSecret,
-		Scope:            fmt.Sprintf("Scope-%s", appID),
-		AccessToken:      RandomToken(),
-		AccessCreateAt:   time.Now(),
-		AccessExpiresIn:  TokenExpiry,
-		RefreshToken:     RandomToken(),
-		RefreshCreateAt:  time.Now(),
-		RefreshExpiresIn: RefreshExpiry,
-	}
-	tdb.tokenStore[token.AccessToken] = token
+		MerchantID:     merchantID,
+		AppID:          appID,
+		Scope:          scope,
+		FamilyID:       familyID,
+		FamilyCreateAt: time.Now(),
+	}
+	tdb.Lock()
+	defer tdb.Unlock()
+	if err = tdb.issue(token, policy); err != nil {
+		return nil, err
+	}
 	return token, nil
 }
 
 // DeleteToken 从DB删除accessToken对应的Token实例
 func (tdb *BackendTokenDB) DeleteToken(accessToken string) error {
-	if _, ok := tdb.tokenStore[accessToken]; !ok {
-		return fmt.Errorf("this accessToken not exist:%s", accessToken)
+	tdb.Lock()
+	defer tdb.Unlock()
+	token, err := tdb.getToken(accessToken)
+	if err != nil {
+		return err
 	}
-	delete(tdb.tokenStore, accessToken)
+	tdb.remove(token)
 	return nil
 }
 
-// GetToken 从DB获取accessToken对应的Token实例
+// GetToken 从DB获取accessToken对应的Token实例，返回的Token不含明文
 func (tdb *BackendTokenDB) GetToken(accessToken string) (*Token, error) {
-	if _, ok := tdb.tokenStore[accessToken]; !ok {
-		return nil, fmt.Errorf("this accessToken not exist:%s", accessToken)
+	tdb.Lock()
+	defer tdb.Unlock()
+	return tdb.getToken(accessToken)
+}
+
+// getToken 同GetToken，调用方需持有锁
+func (tdb *BackendTokenDB) getToken(accessToken string) (*Token, error) {
+	hashes, err := tdb.hasher.Hashes(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, accessHash := range hashes {
+		if token, ok := tdb.tokenStore[accessHash]; ok {
+			return token, nil
+		}
+	}
+	return nil, ErrTokenNotFound
+}
+
+// GetTokenByRefresh 从DB获取refreshToken对应的Token实例，返回的Token不含明文
+func (tdb *BackendTokenDB) GetTokenByRefresh(refreshToken string) (*Token, error) {
+	tdb.Lock()
+	defer tdb.Unlock()
+	return tdb.getTokenByRefresh(refreshToken)
+}
+
+// getTokenByRefresh 同GetTokenByRefresh，调用方需持有锁
+func (tdb *BackendTokenDB) getTokenByRefresh(refreshToken string) (*Token, error) {
+	hashes, err := tdb.hasher.Hashes(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, refreshHash := range hashes {
+		if accessHash, ok := tdb.refreshIndex[refreshHash]; ok {
+			return tdb.tokenStore[accessHash], nil
+		}
+	}
+	return nil, ErrTokenNotFound
+}
+
+// RevokeFamily 吊销Token家族中仍然有效的Token
+func (tdb *BackendTokenDB) RevokeFamily(familyID string) error {
+	tdb.Lock()
+	defer tdb.Unlock()
+	tdb.revokeFamily(familyID)
+	return nil
+}
+
+// revokeFamily 同RevokeFamily，调用方需持有锁
+func (tdb *BackendTokenDB) revokeFamily(familyID string) {
+	for _, token := range tdb.tokenStore {
+		if token.GetFamilyID() == familyID {
+			tdb.remove(token)
+		}
 	}
-	return tdb.tokenStore[accessToken], nil
 }
 
 // VerifyToken 通过DB验证accessToken是否有效
 func (tdb *BackendTokenDB) VerifyToken(accessToken string) error {
-	token, err := tdb.GetToken(accessToken)
+	tdb.Lock()
+	defer tdb.Unlock()
+	token, err := tdb.getToken(accessToken)
 	if err != nil {
 		return err
 	}
 	// 判断accessToken是否过期。创建时间+生存期>当前时间 则表示已经过期。
 	if token.GetAccessCreateAt().Add(token.GetAccessExpiresIn()).Before(time.Now()) {
-		return fmt.Errorf("accessToken expires. accessToken=%s", accessToken)
+		return ErrTokenExpired
 	}
 	return nil
 }
 
-// RefreshToken 对accessToken进行刷新，延长其有效期
-func (tdb *BackendTokenDB) RefreshToken(accessToken string) (string, error) {
-	token, err := tdb.GetToken(accessToken)
+// RefreshToken 使用refreshToken换取新的accessToken与refreshToken，accessToken过期后仍可使用。
+// 不符合policy时删除老的Token，家族随之失效
+func (tdb *BackendTokenDB) RefreshToken(merchantID string, appID string, refreshToken string, policy *TokenPolicy) (*Token, error) {
+	tdb.Lock()
+	defer tdb.Unlock()
+	hashes, err := tdb.hasher.Hashes(refreshToken)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	// 判断accessToken是否过期。创建时间+生存期<当前时间 则过期。
-	if token.GetAccessCreateAt().Add(token.GetAccessExpiresIn()).Before(time.Now()) {
-		// accessToken 已经过期则不再续期
-		return "", fmt.Errorf("accessToken expires. accessToken=%s", accessToken)
+	now := time.Now()
+	for _, refreshHash := range hashes {
+		if used, ok := tdb.usedRefresh[refreshHash]; ok && used.expiresAt.After(now) {
+			tdb.revokeFamily(used.familyID)
+			return &Token{FamilyID: used.familyID}, ErrRefreshTokenReused
+		}
+	}
+	old, err := tdb.getTokenByRefresh(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if old.GetMerchantID() != merchantID || old.GetAppID() != appID {
+		return nil, fmt.Errorf("refreshToken not belong to merchant(%s) app(%s)", merchantID, appID)
 	}
 	// 判断refreshtoken是否过期。创建时间+生存期<当前时间 则过期。
-	if token.GetRefreshCreateAt().Add(token.GetRefreshExpiresIn()).Before(time.Now()) {
-		// refreshtoken 已经过期则不再续期
-		return "", fmt.Errorf("refreshtoken expires. accessToken=%s", accessToken)
+	refreshExpiresAt := old.GetRefreshCreateAt().Add(old.GetRefreshExpiresIn())
+	if refreshExpiresAt.Before(now) {
+		tdb.remove(old)
+		return nil, ErrTokenExpired
+	}
+	if err = policy.CheckRefresh(old, now); err != nil {
+		tdb.remove(old)
+		return nil, err
+	}
+
+	// 轮换：老的refreshToken记为已使用，签发同一家族的新Token
+	tdb.remove(old)
+	for k, used := range tdb.usedRefresh {
+		if !used.expiresAt.After(now) {
+			delete(tdb.usedRefresh, k)
+		}
 	}
-	// 生成新的accessToken
-	token.SetAccessToken(RandomToken())
-	token.SetAccessCreateAt(time.Now())
-	token.SetAccessExpiresIn(TokenExpiry)
-	tdb.tokenStore[token.GetAccessToken()] = token
-	// 删除老的生成新的accessToken
-	if err = tdb.DeleteToken(accessToken); err != nil {
-		return "", err
+	tdb.usedRefresh[old.GetRefreshHash()] = &usedRefresh{familyID: old.GetFamilyID(), expiresAt: refreshExpiresAt}
+	token := &Token{
+		MerchantID:     old.GetMerchantID(),
+		AppID:          old.GetAppID(),
+		Scope:          old.GetScope(),
+		FamilyID:       old.GetFamilyID(),
+		FamilyCreateAt: old.GetFamilyCreateAt(),
+		RefreshCount:   old.GetRefreshCount() + 1,
+	}
+	if err := tdb.issue(token, policy); err != nil {
+		return nil, err
 	}
-	return token.GetAccessToken(), nil
+	return token, nil
 }
 
-// RandomToken 生成一个随机的accessToken，仅测试用
-func RandomToken() string {
-	randStr := funk.RandomString(64)
-	rand.Seed(time.Now().UnixNano())
+// issue 为token生成新的accessToken与refreshToken并保存哈希，有效期由policy决定，调用方需持有锁
+func (tdb *BackendTokenDB) issue(token *Token, policy *TokenPolicy) error {
+	accessToken, err := tdb.generator.Generate(TokenTypeAccess)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := tdb.generator.Generate(TokenTypeRefresh)
+	if err != nil {
+		return err
+	}
+	accessHash, err := tdb.hasher.Hash(accessToken)
+	if err != nil {
+		return err
+	}
+	refreshHash, err := tdb.hasher.Hash(refreshToken)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	accessTTL, refreshTTL := policy.Lifetimes(token.GetFamilyCreateAt(), now)
+	token.AccessToken, token.AccessHash = accessToken, accessHash
+	token.AccessCreateAt, token.AccessExpiresIn = now, accessTTL
+	token.RefreshToken, token.RefreshHash = refreshToken, refreshHash
+	token.RefreshCreateAt, token.RefreshExpiresIn = now, refreshTTL
+	tdb.tokenStore[token.AccessHash] = token.redact()
+	tdb.refreshIndex[token.RefreshHash] = token.AccessHash
+	return nil
+}
 
-	randSubStr := []string{}
-	for i := 0; i < len(randStr); i++ {
-		randIdx := rand.Uint32() % 64
-		randSubStr = append(randSubStr, string(randStr[randIdx]))
+// remove 删除Token及其refreshToken索引
+func (tdb *BackendTokenDB) remove(token *Token) {
+	delete(tdb.tokenStore, token.GetAccessHash())
+	delete(tdb.refreshIndex, token.GetRefreshHash())
+}
+
+// BackendCodeDB 实现CodeDB接口的后端数据库，仅示意
+type BackendCodeDB struct {
+	sync.Mutex
+	codeStore map[string]*AuthorizationCode // key:授权码的SHA-256, value:未使用的授权码
+	usedStore map[string]*AuthorizationCode // key:授权码的SHA-256, value:已使用的授权码，保留到过期
+}
+
+// NewBackendCodeDB 生成BackendCodeDB实例
+func NewBackendCodeDB() *BackendCodeDB {
+	return &BackendCodeDB{
+		codeStore: map[string]*AuthorizationCode{},
+		usedStore: map[string]*AuthorizationCode{},
+	}
+}
+
+// SaveCode 保存授权码，只保存哈希，同时清理已过期的记录
+func (cdb *BackendCodeDB) SaveCode(code *AuthorizationCode) error {
+	cdb.Lock()
+	defer cdb.Unlock()
+	now := time.Now()
+	for _, store := range []map[string]*AuthorizationCode{cdb.codeStore, cdb.usedStore} {
+		for k, c := range store {
+			if c.expired(now) {
+				delete(store, k)
+			}
+		}
+	}
+	codeHash := crypt.Sha256String(code.Code, false)
+	if _, ok := cdb.codeStore[codeHash]; ok {
+		return fmt.Errorf("this code already exist:%s", codeHash)
+	}
+	stored := *code
+	stored.Code = ""
+	cdb.codeStore[codeHash] = &stored
+	return nil
+}
+
+// ConsumeCode 取出授权码并标记为已使用
+func (cdb *BackendCodeDB) ConsumeCode(code string) (*AuthorizationCode, error) {
+	cdb.Lock()
+	defer cdb.Unlock()
+	codeHash := crypt.Sha256String(code, false)
+	if used, ok := cdb.usedStore[codeHash]; ok && !used.expired(time.Now()) {
+		return used, ErrAuthCodeReused
+	}
+	c, ok := cdb.codeStore[codeHash]
+	if !ok {
+		return nil, fmt.Errorf("this code not exist:%s", codeHash)
+	}
+	delete(cdb.codeStore, codeHash)
+	cdb.usedStore[codeHash] = c
+	return c, nil
+}
+
+// BindFamily 记录已使用的授权码换取的Token家族
+func (cdb *BackendCodeDB) BindFamily(code string, familyID string) error {
+	cdb.Lock()
+	defer cdb.Unlock()
+	codeHash := crypt.Sha256String(code, false)
+	used, ok := cdb.usedStore[codeHash]
+	if !ok {
+		return fmt.Errorf("this code not exist:%s", codeHash)
+	}
+	used.FamilyID = familyID
+	return nil
+}
+
+// RandomToken 生成一个64位的base62随机串，基于crypto/rand
+//
+// Deprecated: 使用TokenGenerator生成带类型前缀与校验和的Token
+func RandomToken() string {
+	token, err := randomBase62(64)
+	if err != nil {
+		panic(err)
 	}
-	return strings.Join(randSubStr, "")
+	return token
 }
@@ -1,6 +1,9 @@
 package oauth
 
 import (
+	"encoding/json"
+	"path/filepath"
+	"saas/crypt"
 	"testing"
 	"time"
 
@@ -15,8 +18,8 @@ func TestDB(t *testing.T) {
 
 	Convey("BackendMerchantDB", t, func() {
 		merchant := NewMerchant("Tencent", alphanum)
-		merchant.AddApp(&Application{"AppID1", "AppID1Secret", "AppID1Scope", "AppID1Name"})
-		merchant.AddApp(&Application{"AppID2", "AppID2Secret", "AppID2Scope", "AppID2Name"})
+		merchant.AddApp(&Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "AppID1Scope", AppName: "AppID1Name"})
+		merchant.AddApp(&Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "AppID2Scope", AppName: "AppID2Name"})
 
 		mdb := NewBackendMerchantDB()
 		err := mdb.Create(merchant)
@@ -27,6 +30,24 @@ func TestDB(t *testing.T) {
 		So(err, ShouldBeNil)
 		err = mdb.Create(m)
 		So(err, ShouldBeError)
+
+		// AppID在MerchantDB中唯一
+		other := NewMerchant("Alibaba", alphanum)
+		other.AddApp(&Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		So(mdb.Create(other), ShouldBeError)
+		other.DelApp(other.GetApp("AppID1"))
+		other.AddApp(&Application{AppID: "AppID3", Scope: "AppID3Scope", AppName: "AppID3Name"})
+		So(mdb.Create(other), ShouldBeNil)
+		updated := NewMerchant("Alibaba", alphanum)
+		updated.AddApp(&Application{AppID: "AppID2", Scope: "AppID2Scope", AppName: "AppID2Name"})
+		So(mdb.Update(updated), ShouldBeError)
+		m, err = mdb.ReadByApp("AppID2")
+		So(err, ShouldBeNil)
+		So(m.MerchantID, ShouldEqual, "Tencent")
+		m, err = mdb.ReadByApp("AppID3")
+		So(err, ShouldBeNil)
+		So(m.MerchantID, ShouldEqual, "Alibaba")
+
 		err = mdb.Delete("Tencent")
 		So(err, ShouldBeNil)
 		t.Logf("%s", m.Prettify())
@@ -35,20 +56,120 @@ func TestDB(t *testing.T) {
 	Convey("BackendTokenDB", t, func() {
 
 		tdb := NewBackendTokenDB()
-		token, err := tdb.CreateToken("AppID-Tencent", "AppSecret-Tencent")
+		token, err := tdb.CreateToken("Tencent", "AppID-Tencent", "Scope-Tencent", nil)
 		accessToken := token.AccessToken
 		So(err, ShouldBeNil)
 		_, err = tdb.GetToken(accessToken)
 		So(err, ShouldBeNil)
 
-		_, err = tdb.RefreshToken(accessToken)
+		// 其他商户不能使用该refreshToken，且不会轮换
+		_, err = tdb.RefreshToken("Alibaba", "AppID-Tencent", token.RefreshToken, nil)
+		So(err, ShouldBeError)
+		_, err = tdb.GetToken(accessToken)
+		So(err, ShouldBeNil)
+
+		_, err = tdb.RefreshToken("Tencent", "AppID-Tencent", token.RefreshToken, nil)
 		So(err, ShouldBeNil)
 		err = tdb.DeleteToken(accessToken)
-		So(err, ShouldBeError) // RefreshToken 之后老的accessToken失效
+		So(err, ShouldEqual, ErrTokenNotFound) // RefreshToken 之后老的accessToken失效
+		So(err.Error(), ShouldNotContainSubstring, token.GetAccessHash())
+		_, err = tdb.GetTokenByRefresh(token.RefreshToken)
+		So(err, ShouldEqual, ErrTokenNotFound)
 		t.Logf("%s", token.Prettify())
 
 	})
 
+	Convey("BackendTokenDB hash at rest", t, func() {
+
+		tdb := NewBackendTokenDB()
+		hasher, err := NewTokenHasher([]byte("0123456789abcdef0123456789abcdef"))
+		So(err, ShouldBeNil)
+		tdb.SetTokenHasher(hasher)
+		token, err := tdb.CreateToken("Tencent", "AppID-Tencent", "Scope-Tencent", nil)
+		So(err, ShouldBeNil)
+		So(token.AccessToken, ShouldNotBeEmpty)
+		accessHash, err := hasher.Hash(token.AccessToken)
+		So(err, ShouldBeNil)
+		So(token.AccessHash, ShouldEqual, accessHash)
+		refreshHash, err := hasher.Hash(token.RefreshToken)
+		So(err, ShouldBeNil)
+		So(token.RefreshHash, ShouldEqual, refreshHash)
+
+		// DB中只有哈希，没有明文
+		stored, err := tdb.GetToken(token.AccessToken)
+		So(err, ShouldBeNil)
+		So(stored.AccessToken, ShouldBeEmpty)
+		So(stored.RefreshToken, ShouldBeEmpty)
+		So(tdb.tokenStore, ShouldContainKey, token.AccessHash)
+		So(tdb.tokenStore, ShouldNotContainKey, token.AccessToken)
+		buf, err := json.Marshal(stored)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldNotContainSubstring, token.AccessToken)
+
+		// 用哈希本身不能查找
+		_, err = tdb.GetToken(token.AccessHash)
+		So(err, ShouldBeError)
+
+		newToken, err := tdb.RefreshToken("Tencent", "AppID-Tencent", token.RefreshToken, nil)
+		So(err, ShouldBeNil)
+		So(tdb.VerifyToken(newToken.AccessToken), ShouldBeNil)
+		accessHash, err = hasher.Hash(newToken.AccessToken)
+		So(err, ShouldBeNil)
+		So(tdb.tokenStore, ShouldContainKey, accessHash)
+		So(tdb.tokenStore, ShouldHaveLength, 1)
+
+	})
+
+	Convey("BackendTokenDB hash key rotation", t, func() {
+
+		km, err := crypt.NewLocalKeyManager(filepath.Join(t.TempDir(), "keys.db"), []byte("E15E2A40282E42E01163B2643C208505"))
+		So(err, ShouldBeNil)
+		keyID, err := km.CreateKey("token-hash", crypt.KeyPurposeEncrypt)
+		So(err, ShouldBeNil)
+		_, err = NewTokenHasherWithKeyManager(km, "not-exist")
+		So(err, ShouldEqual, crypt.ErrKeyNotFound)
+		hasher, err := NewTokenHasherWithKeyManager(km, "token-hash")
+		So(err, ShouldBeNil)
+		tdb := NewBackendTokenDB()
+		tdb.SetTokenHasher(hasher)
+		token1, err := tdb.CreateToken("Tencent", "AppID-Tencent", "Scope-Tencent", nil)
+		So(err, ShouldBeNil)
+
+		// 轮换后新Token使用新版本，老Token仍可查找与刷新
+		_, err = km.Rotate("token-hash")
+		So(err, ShouldBeNil)
+		token2, err := tdb.CreateToken("Tencent", "AppID-Tencent", "Scope-Tencent", nil)
+		So(err, ShouldBeNil)
+		hashes, err := hasher.Hashes(token1.AccessToken)
+		So(err, ShouldBeNil)
+		So(hashes, ShouldHaveLength, 2)
+		So(hashes[1], ShouldEqual, token1.AccessHash)
+		So(tdb.VerifyToken(token1.AccessToken), ShouldBeNil)
+		So(tdb.VerifyToken(token2.AccessToken), ShouldBeNil)
+		refreshed, err := tdb.RefreshToken("Tencent", "AppID-Tencent", token1.RefreshToken, nil)
+		So(err, ShouldBeNil)
+		So(tdb.VerifyToken(refreshed.AccessToken), ShouldBeNil)
+		_, err = tdb.RefreshToken("Tencent", "AppID-Tencent", token1.RefreshToken, nil)
+		So(err, ShouldEqual, ErrRefreshTokenReused)
+
+		// 老版本退役后由其签发的Token失效
+		_, err = km.Rotate("token-hash")
+		So(err, ShouldBeNil)
+		So(km.Retire(keyID), ShouldBeNil)
+		So(tdb.VerifyToken(token2.AccessToken), ShouldBeNil)
+		token4, err := tdb.CreateToken("Tencent", "AppID-Tencent", "Scope-Tencent", nil)
+		So(err, ShouldBeNil)
+		So(tdb.VerifyToken(token4.AccessToken), ShouldBeNil)
+		_, err = km.Rotate("token-hash")
+		So(err, ShouldBeNil)
+		versions, err := km.Versions("token-hash")
+		So(err, ShouldBeNil)
+		So(km.Retire(versions[2]), ShouldBeNil)
+		So(tdb.VerifyToken(token2.AccessToken), ShouldBeError)
+		So(tdb.VerifyToken(token4.AccessToken), ShouldBeNil)
+
+	})
+
 }
 
 func TestRandomToken(t *testing.T) {
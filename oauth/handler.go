@@ -0,0 +1,132 @@
+package oauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// OAuth错误码(RFC 6749 5.2、RFC 6750 3.1)，作为HTTP接口ErrorResponse.Error的取值，保持稳定不变
+const (
+	ErrCodeInvalidRequest          = "invalid_request"
+	ErrCodeInvalidClient           = "invalid_client"
+	ErrCodeInvalidGrant            = "invalid_grant"
+	ErrCodeInvalidScope            = "invalid_scope"
+	ErrCodeUnsupportedGrantType    = "unsupported_grant_type"
+	ErrCodeInvalidToken            = "invalid_token"
+	ErrCodeInsufficientScope       = "insufficient_scope"
+	ErrCodeUnsupportedResponseType = "unsupported_response_type"
+	ErrCodeAccessDenied            = "access_denied"
+	ErrCodeNotFound                = "not_found"
+	ErrCodeServerError             = "server_error"
+)
+
+// ErrorResponse HTTP接口的错误响应，所有接口的错误都使用该结构
+type ErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// IntrospectHandler 返回Token内省接口(RFC 7662)的http.Handler。
+// 请求为POST表单，参数merchant_id、app_id、token、token_type_hint，
+// 需由商户私钥通过crypt.SignHTTPRequest签名；只返回该商户App自己的Token，其他Token视为无效
+func (o *OAuth) IntrospectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkFormPost(w, r) {
+			return
+		}
+		form, err := readForm(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err = checkParams(form, "merchant_id", "app_id", "token"); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		mInfo := &MerchantInfo{MerchantID: form.Get("merchant_id"), AppID: form.Get("app_id")}
+		if err = o.VerifyMerchantHTTPRequest(mInfo.MerchantID, r); err != nil {
+			writeError(w, http.StatusUnauthorized, ErrCodeInvalidClient, err.Error())
+			return
+		}
+		result, err := o.IntrospectOwn(mInfo, form.Get("token"), form.Get("token_type_hint"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeServerError, "")
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// RevokeHandler 返回Token吊销接口(RFC 7009)的http.Handler。
+// 请求为POST表单，参数merchant_id、app_id、token、token_type_hint，
+// 需由商户私钥通过crypt.SignHTTPRequest签名
+func (o *OAuth) RevokeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkFormPost(w, r) {
+			return
+		}
+		form, err := readForm(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err = checkParams(form, "merchant_id", "app_id", "token"); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		mInfo := &MerchantInfo{MerchantID: form.Get("merchant_id"), AppID: form.Get("app_id")}
+		token := form.Get("token")
+		if err = o.VerifyMerchantHTTPRequest(mInfo.MerchantID, r); err != nil {
+			writeError(w, http.StatusUnauthorized, ErrCodeInvalidClient, err.Error())
+			return
+		}
+		if err = o.Revoke(mInfo, token, form.Get("token_type_hint")); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// checkFormPost 校验请求为POST表单，不满足时输出错误响应并返回false
+func checkFormPost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeInvalidRequest, "method not allowed")
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		writeError(w, http.StatusUnsupportedMediaType, ErrCodeInvalidRequest, "content type must be application/x-www-form-urlencoded")
+		return false
+	}
+	return true
+}
+
+// readForm 解析表单请求体后恢复r.Body，便于后续验签
+func readForm(r *http.Request) (url.Values, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return url.ParseQuery(string(body))
+}
+
+// writeJSON 输出JSON响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 输出错误响应
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, &ErrorResponse{Error: code, Description: description})
+}
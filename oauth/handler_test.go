@@ -0,0 +1,103 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"saas/crypt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newFormRequest 生成POST表单请求
+func newFormRequest(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestHandler(t *testing.T) {
+
+	Convey("IntrospectHandler", t, func() {
+		o := newTestOAuth()
+		token, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		form := url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}, "token": {token.AccessToken}}
+
+		// 未签名
+		w := httptest.NewRecorder()
+		o.IntrospectHandler().ServeHTTP(w, newFormRequest("/introspect", form))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+
+		r := newFormRequest("/introspect", form)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		o.IntrospectHandler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+		result := &Introspection{}
+		So(json.Unmarshal(w.Body.Bytes(), result), ShouldBeNil)
+		So(result.Active, ShouldBeTrue)
+		So(result.ClientID, ShouldEqual, "AppID1")
+
+		// 其他App的Token不暴露归属
+		form.Set("app_id", "AppID2")
+		r = newFormRequest("/introspect", form)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		o.IntrospectHandler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(strings.TrimSpace(w.Body.String()), ShouldEqual, `{"active":false}`)
+
+		form.Set("token", "unknown")
+		r = newFormRequest("/introspect", form)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		o.IntrospectHandler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(strings.TrimSpace(w.Body.String()), ShouldEqual, `{"active":false}`)
+
+		r = newFormRequest("/introspect", url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}})
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		o.IntrospectHandler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		errResp := &ErrorResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), errResp), ShouldBeNil)
+		So(errResp.Error, ShouldEqual, ErrCodeInvalidRequest)
+
+		w = httptest.NewRecorder()
+		o.IntrospectHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/introspect", nil))
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+	})
+
+	Convey("RevokeHandler", t, func() {
+		o := newTestOAuth()
+		token, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		form := url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}, "token": {token.RefreshToken}}
+
+		// 未签名
+		w := httptest.NewRecorder()
+		o.RevokeHandler().ServeHTTP(w, newFormRequest("/revoke", form))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+
+		r := newFormRequest("/revoke", form)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		o.RevokeHandler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		result, err := o.Introspect(token.AccessToken, "")
+		So(err, ShouldBeNil)
+		So(result.Active, ShouldBeFalse)
+
+		r = newFormRequest("/revoke", url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}})
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = httptest.NewRecorder()
+		o.RevokeHandler().ServeHTTP(w, r)
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+	})
+}
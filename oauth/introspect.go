@@ -0,0 +1,135 @@
+package oauth
+
+import (
+	"fmt"
+	"time"
+)
+
+// token_type_hint取值(RFC 7009、RFC 7662)
+const (
+	TokenHintAccess  = "access_token"
+	TokenHintRefresh = "refresh_token"
+)
+
+// Introspection Token内省结果(RFC 7662)，Token无效时只有Active字段
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"` // AppID
+	Subject   string `json:"sub,omitempty"`       // MerchantID
+	Tenant    string `json:"tenant,omitempty"`
+	TokenUse  string `json:"token_use,omitempty"` // TokenHintAccess或TokenHintRefresh
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// tokenRecord 查找到的Token及其归属
+type tokenRecord struct {
+	use       string
+	token     *Token     // 不透明Token与refreshtoken
+	claims    *JWTClaims // JWT模式下的accesstoken
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// Introspect 查询Token的状态与归属，token可以是accesstoken或refreshtoken，hint为空时先按accesstoken查找。
+// Token不存在、已过期或已吊销时返回Active为false的结果。不校验调用方，对外提供时使用IntrospectOwn
+func (o *OAuth) Introspect(token, hint string) (*Introspection, error) {
+	rec := o.lookupToken(token, hint)
+	if rec == nil || !rec.expiresAt.After(time.Now()) {
+		return &Introspection{Active: false}, nil
+	}
+	if rec.claims != nil {
+		revoked, err := o.jwtIssuer.isRevoked(rec.claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return &Introspection{Active: false}, nil
+		}
+		return &Introspection{
+			Active:    true,
+			Scope:     rec.claims.Scope,
+			ClientID:  rec.claims.AppID,
+			Subject:   rec.claims.Subject,
+			Tenant:    rec.claims.Tenant,
+			TokenUse:  rec.use,
+			IssuedAt:  rec.claims.IssuedAt,
+			ExpiresAt: rec.claims.ExpiresAt,
+		}, nil
+	}
+	return &Introspection{
+		Active:    true,
+		Scope:     rec.token.GetScope(),
+		ClientID:  rec.token.GetAppID(),
+		Subject:   rec.token.GetMerchantID(),
+		Tenant:    o.tenant,
+		TokenUse:  rec.use,
+		IssuedAt:  rec.issuedAt.Unix(),
+		ExpiresAt: rec.expiresAt.Unix(),
+	}, nil
+}
+
+// IntrospectOwn 同Introspect，但只返回属于商户mInfo的App的Token，
+// 其他Token返回Active为false的结果，不暴露其归属
+func (o *OAuth) IntrospectOwn(mInfo *MerchantInfo, token, hint string) (*Introspection, error) {
+	result, err := o.Introspect(token, hint)
+	if err != nil {
+		return nil, err
+	}
+	if result.Subject != mInfo.MerchantID || result.ClientID != mInfo.AppID {
+		return &Introspection{Active: false}, nil
+	}
+	return result, nil
+}
+
+// Revoke 吊销商户某个App的Token(RFC 7009)，token可以是accesstoken或refreshtoken。
+// 吊销refreshtoken会吊销整个Token家族；Token不存在时视为成功，Token不属于该App时返回错误
+func (o *OAuth) Revoke(mInfo *MerchantInfo, token, hint string) error {
+	rec := o.lookupToken(token, hint)
+	if rec == nil {
+		return nil
+	}
+	if rec.claims != nil {
+		if err := rec.claims.checkOwner(mInfo); err != nil {
+			return err
+		}
+		return o.jwtIssuer.Revoke(rec.claims)
+	}
+	if rec.token.GetMerchantID() != mInfo.MerchantID || rec.token.GetAppID() != mInfo.AppID {
+		return fmt.Errorf("token not belong to merchant(%s) app(%s)", mInfo.MerchantID, mInfo.AppID)
+	}
+	if rec.use == TokenHintRefresh {
+		return o.revokeFamily(rec.token.GetFamilyID(), o.appTokenPolicy(mInfo.MerchantID, mInfo.AppID))
+	}
+	return o.tokenDB.DeleteToken(token)
+}
+
+// lookupToken 按hint指定的顺序查找Token，不校验有效期，找不到时返回nil
+func (o *OAuth) lookupToken(token, hint string) *tokenRecord {
+	uses := []string{TokenHintAccess, TokenHintRefresh}
+	if hint == TokenHintRefresh {
+		uses = []string{TokenHintRefresh, TokenHintAccess}
+	}
+	for _, use := range uses {
+		if use == TokenHintRefresh {
+			if t, err := o.tokenDB.GetTokenByRefresh(token); err == nil {
+				return &tokenRecord{use: use, token: t, issuedAt: t.GetRefreshCreateAt(),
+					expiresAt: t.GetRefreshCreateAt().Add(t.GetRefreshExpiresIn())}
+			}
+			continue
+		}
+		if o.jwtIssuer != nil {
+			if claims, err := o.jwtIssuer.parse(token); err == nil {
+				return &tokenRecord{use: use, claims: claims, issuedAt: time.Unix(claims.IssuedAt, 0),
+					expiresAt: time.Unix(claims.ExpiresAt, 0)}
+			}
+			continue
+		}
+		if t, err := o.tokenDB.GetToken(token); err == nil {
+			return &tokenRecord{use: use, token: t, issuedAt: t.GetAccessCreateAt(),
+				expiresAt: t.GetAccessCreateAt().Add(t.GetAccessExpiresIn())}
+		}
+	}
+	return nil
+}
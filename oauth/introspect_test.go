@@ -0,0 +1,99 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestOAuth 生成包含商户Tencent及AppID1、AppID2的OAuth实例
+func newTestOAuth() *OAuth {
+	merchant := NewMerchant("Tencent", publicKey)
+	merchant.AddApp(&Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "orders users:read", AppName: "AppID1Name"})
+	merchant.AddApp(&Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "orders", AppName: "AppID2Name"})
+	o := NewOAuth(NewBackendMerchantDB(), NewBackendTokenDB())
+	o.MerchantDB().Create(merchant)
+	o.SetTenant("tenant1")
+	return o
+}
+
+// issueTestToken 为商户Tencent的App签发Token
+func issueTestToken(o *OAuth, appID string) (*Token, error) {
+	mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: appID}
+	targetSign, err := SignMerchantInfo(privateKey, mInfo)
+	if err != nil {
+		return nil, err
+	}
+	return o.IssueToken(mInfo, targetSign)
+}
+
+func TestIntrospect(t *testing.T) {
+
+	Convey("Introspect", t, func() {
+		issuer, err := NewJWTIssuer(privateKey, "saas", "tenant1")
+		So(err, ShouldBeNil)
+
+		for _, jwtIssuer := range []*JWTIssuer{nil, issuer} {
+			o := newTestOAuth()
+			o.SetJWTIssuer(jwtIssuer)
+			token, err := issueTestToken(o, "AppID1")
+			So(err, ShouldBeNil)
+
+			result, err := o.Introspect(token.AccessToken, "")
+			So(err, ShouldBeNil)
+			So(result.Active, ShouldBeTrue)
+			So(result.Subject, ShouldEqual, "Tencent")
+			So(result.ClientID, ShouldEqual, "AppID1")
+			So(result.Tenant, ShouldEqual, "tenant1")
+			So(result.Scope, ShouldEqual, "orders users:read")
+			So(result.TokenUse, ShouldEqual, TokenHintAccess)
+			So(result.ExpiresAt-result.IssuedAt, ShouldEqual, int64(TokenExpiry/time.Second))
+
+			// hint错误时仍能找到
+			result, err = o.Introspect(token.RefreshToken, TokenHintAccess)
+			So(err, ShouldBeNil)
+			So(result.Active, ShouldBeTrue)
+			So(result.TokenUse, ShouldEqual, TokenHintRefresh)
+			So(result.ExpiresAt-result.IssuedAt, ShouldEqual, int64(RefreshExpiry/time.Second))
+
+			result, err = o.Introspect("unknown", TokenHintRefresh)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, &Introspection{Active: false})
+		}
+	})
+
+	Convey("Revoke", t, func() {
+		issuer, err := NewJWTIssuer(privateKey, "saas", "tenant1")
+		So(err, ShouldBeNil)
+
+		for _, jwtIssuer := range []*JWTIssuer{nil, issuer} {
+			o := newTestOAuth()
+			o.SetJWTIssuer(jwtIssuer)
+			mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+			token, err := issueTestToken(o, "AppID1")
+			So(err, ShouldBeNil)
+
+			// 不属于该App的Token不能吊销，未知Token视为成功
+			So(o.Revoke(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID2"}, token.AccessToken, ""), ShouldBeError)
+			So(o.Revoke(mInfo, "unknown", ""), ShouldBeNil)
+
+			So(o.Revoke(mInfo, token.AccessToken, TokenHintAccess), ShouldBeNil)
+			result, err := o.Introspect(token.AccessToken, "")
+			So(err, ShouldBeNil)
+			So(result.Active, ShouldBeFalse)
+			So(o.Revoke(mInfo, token.AccessToken, ""), ShouldBeNil)
+
+			// 吊销refreshtoken会吊销整个家族
+			token, err = issueTestToken(o, "AppID1")
+			So(err, ShouldBeNil)
+			So(o.Revoke(mInfo, token.RefreshToken, TokenHintRefresh), ShouldBeNil)
+			result, err = o.Introspect(token.RefreshToken, TokenHintRefresh)
+			So(err, ShouldBeNil)
+			So(result.Active, ShouldBeFalse)
+			_, err = o.RefreshToken(mInfo, token.RefreshToken)
+			So(err, ShouldBeError)
+			So(o.VerifyToken(mInfo, token.AccessToken), ShouldBeError)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package oauth
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"saas/crypt"
+	"sync"
+	"time"
+)
+
+// ErrTokenRevoked Token已被吊销
+var ErrTokenRevoked = errors.New("token revoked")
+
+// JWTClaims JWT格式accesstoken中携带的声明
+type JWTClaims struct {
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"` // merchantID
+	AppID     string `json:"app_id"`
+	Tenant    string `json:"tenant,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	FamilyID  string `json:"fid,omitempty"` // 所属Token家族，家族被吊销时一并失效
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenDenylist 记录已吊销的JWT与Token家族，key为jti或familyKey，Token过期后可以删除对应记录
+type TokenDenylist interface {
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+// BackendTokenDenylist 实现TokenDenylist接口的后端数据库，仅示意
+type BackendTokenDenylist struct {
+	sync.RWMutex
+	revoked map[string]time.Time // key:jti, value:Token过期时间
+}
+
+// NewBackendTokenDenylist 生成BackendTokenDenylist实例
+func NewBackendTokenDenylist() *BackendTokenDenylist {
+	return &BackendTokenDenylist{revoked: map[string]time.Time{}}
+}
+
+// Revoke 吊销jti，同时清理已过期的记录
+func (d *BackendTokenDenylist) Revoke(jti string, expiresAt time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+	now := time.Now()
+	for k, exp := range d.revoked {
+		if exp.Before(now) {
+			delete(d.revoked, k)
+		}
+	}
+	d.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsRevoked 判断jti是否已吊销
+func (d *BackendTokenDenylist) IsRevoked(jti string) (bool, error) {
+	d.RLock()
+	defer d.RUnlock()
+	_, ok := d.revoked[jti]
+	return ok, nil
+}
+
+// JWTIssuer 签发与无状态校验JWT格式的accesstoken。
+// 算法由私钥类型决定：RSA为RS256，ECDSA P-256为ES256，Ed25519为EdDSA；kid为公钥的RFC 7638指纹。
+// 使用KeyManager时kid为密钥ID，以主版本签名，按kid选择未退役的版本验签
+type JWTIssuer struct {
+	key       crypto.Signer
+	kid       string
+	km        crypt.KeyManager // 不为nil时签名密钥由KeyManager管理，key与kid不使用
+	keyName   string
+	issuer    string
+	tenant    string
+	expiresIn time.Duration
+	denylist  TokenDenylist
+}
+
+// NewJWTIssuer 生成JWTIssuer实例，privateKey为PEM格式私钥，tenant写入tenant声明，可以为空
+func NewJWTIssuer(privateKey, issuer, tenant string) (*JWTIssuer, error) {
+	key, err := crypt.LoadAnyPrivateKey([]byte(privateKey))
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := crypt.NewJWK(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = crypt.JWSAlgorithm(key.Public()); err != nil {
+		return nil, err
+	}
+	return &JWTIssuer{
+		key:       key,
+		kid:       jwk.Kid,
+		issuer:    issuer,
+		tenant:    tenant,
+		expiresIn: TokenExpiry,
+		denylist:  NewBackendTokenDenylist(),
+	}, nil
+}
+
+// NewJWTIssuerWithKeyManager 生成使用KeyManager中签名密钥name的JWTIssuer实例，
+// 密钥轮换后新JWT使用新版本签名，老JWT在其版本退役前仍可校验
+func NewJWTIssuerWithKeyManager(km crypt.KeyManager, name, issuer, tenant string) (*JWTIssuer, error) {
+	j := &JWTIssuer{
+		km:        km,
+		keyName:   name,
+		issuer:    issuer,
+		tenant:    tenant,
+		expiresIn: TokenExpiry,
+		denylist:  NewBackendTokenDenylist(),
+	}
+	// 校验密钥存在、用途为签名且算法受支持
+	kid, key, err := j.signer()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = crypt.JWSAlgorithm(key.Public()); err != nil {
+		return nil, fmt.Errorf("jwt key %s: %v", kid, err)
+	}
+	return j, nil
+}
+
+// SetExpiresIn 设置accesstoken的有效期，默认为TokenExpiry
+func (j *JWTIssuer) SetExpiresIn(expiresIn time.Duration) {
+	j.expiresIn = expiresIn
+}
+
+// SetDenylist 设置吊销列表，多实例部署时应使用共享存储实现
+func (j *JWTIssuer) SetDenylist(denylist TokenDenylist) {
+	j.denylist = denylist
+}
+
+// JWKS 返回签名公钥的JWKS，供网关等校验方离线验签。使用KeyManager时包含所有未退役的版本
+func (j *JWTIssuer) JWKS() (*crypt.JWKS, error) {
+	if j.km == nil {
+		jwk, err := newSigJWK(j.key.Public())
+		if err != nil {
+			return nil, err
+		}
+		return &crypt.JWKS{Keys: []*crypt.JWK{jwk}}, nil
+	}
+	versions, err := j.km.Versions(j.keyName)
+	if err != nil {
+		return nil, err
+	}
+	jwks := crypt.NewJWKS()
+	for _, keyID := range versions {
+		pub, err := j.km.VerifyKey(keyID)
+		if err != nil {
+			return nil, err
+		}
+		jwk, err := newSigJWK(pub)
+		if err != nil {
+			return nil, err
+		}
+		jwk.Kid = keyID
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// newSigJWK 生成用于签名的JWK
+func newSigJWK(pub crypto.PublicKey) (*crypt.JWK, error) {
+	jwk, err := crypt.NewJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = "sig"
+	jwk.Alg, _ = crypt.JWSAlgorithm(pub)
+	return jwk, nil
+}
+
+// signer 返回签名使用的kid与私钥，使用KeyManager时为主版本
+func (j *JWTIssuer) signer() (string, crypto.Signer, error) {
+	if j.km == nil {
+		return j.kid, j.key, nil
+	}
+	versions, err := j.km.Versions(j.keyName)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(versions) == 0 {
+		return "", nil, crypt.ErrKeyNotFound
+	}
+	key, err := j.km.Signer(versions[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return versions[0], key, nil
+}
+
+// verifyKey 按kid返回验签公钥，使用KeyManager时kid必须属于该签名密钥且未退役
+func (j *JWTIssuer) verifyKey(kid string) (crypto.PublicKey, error) {
+	if j.km == nil {
+		if kid != j.kid {
+			return nil, fmt.Errorf("jwt kid mismatch: %s", kid)
+		}
+		return j.key.Public(), nil
+	}
+	name, _, err := crypt.ParseKeyID(kid)
+	if err != nil || name != j.keyName {
+		return nil, fmt.Errorf("jwt kid mismatch: %s", kid)
+	}
+	return j.km.VerifyKey(kid)
+}
+
+// Issue 签发JWT
+func (j *JWTIssuer) Issue(merchantID, appID, scope string) (string, error) {
+	return j.issue(merchantID, appID, scope, "", j.expiresIn)
+}
+
+// issue 为Token家族签发指定有效期的JWT，familyID为空时不属于任何家族
+func (j *JWTIssuer) issue(merchantID, appID, scope, familyID string, expiresIn time.Duration) (string, error) {
+	jti, err := crypt.RandomNonce()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(&JWTClaims{
+		ID:        jti,
+		Issuer:    j.issuer,
+		Subject:   merchantID,
+		AppID:     appID,
+		Tenant:    j.tenant,
+		Scope:     scope,
+		FamilyID:  familyID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(expiresIn).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	kid, key, err := j.signer()
+	if err != nil {
+		return "", err
+	}
+	return crypt.SignJWS(key, kid, payload)
+}
+
+// Parse 校验JWT的签名、签发方、有效期与吊销状态，返回其中的声明
+func (j *JWTIssuer) Parse(token string) (*JWTClaims, error) {
+	claims, err := j.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("accessToken expires. jti=%s", claims.ID)
+	}
+	revoked, err := j.isRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// isRevoked 判断JWT本身或其所属的Token家族是否已吊销
+func (j *JWTIssuer) isRevoked(claims *JWTClaims) (bool, error) {
+	revoked, err := j.denylist.IsRevoked(claims.ID)
+	if err != nil || revoked || claims.FamilyID == "" {
+		return revoked, err
+	}
+	return j.denylist.IsRevoked(familyKey(claims.FamilyID))
+}
+
+// Revoke 吊销JWT，已过期的JWT无需吊销
+func (j *JWTIssuer) Revoke(claims *JWTClaims) error {
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if expiresAt.Before(time.Now()) {
+		return nil
+	}
+	return j.denylist.Revoke(claims.ID, expiresAt)
+}
+
+// RevokeFamily 吊销Token家族已签发的全部JWT，expiresIn为家族中JWT的最长有效期，
+// 吊销记录保留到这些JWT全部过期
+func (j *JWTIssuer) RevokeFamily(familyID string, expiresIn time.Duration) error {
+	return j.denylist.Revoke(familyKey(familyID), time.Now().Add(expiresIn))
+}
+
+// familyKey 返回Token家族在吊销列表中的key，jti为hex串，不会与之冲突
+func familyKey(familyID string) string {
+	return "family:" + familyID
+}
+
+// parse 校验签名、kid与签发方，不校验有效期与吊销状态
+func (j *JWTIssuer) parse(token string) (*JWTClaims, error) {
+	header, err := crypt.ParseJWSHeader(token)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := j.verifyKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	_, payload, err := crypt.VerifyJWS(pub, token)
+	if err != nil {
+		return nil, err
+	}
+	claims := &JWTClaims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != j.issuer || claims.Tenant != j.tenant || claims.ID == "" {
+		return nil, fmt.Errorf("jwt claims invalid: iss=%s, tenant=%s", claims.Issuer, claims.Tenant)
+	}
+	return claims, nil
+}
+
+// checkOwner 判断声明是否属于商户的App
+func (c *JWTClaims) checkOwner(mInfo *MerchantInfo) error {
+	if c.Subject != mInfo.MerchantID || c.AppID != mInfo.AppID {
+		return fmt.Errorf("accessToken not belong to merchant(%s) app(%s)", mInfo.MerchantID, mInfo.AppID)
+	}
+	return nil
+}
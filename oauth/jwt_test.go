@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"saas/crypt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJWTIssuer(t *testing.T) {
+
+	Convey("Issue&Parse", t, func() {
+		ecPrivateKey, _, err := crypt.GenerateECDSAKeyStr(elliptic.P256())
+		So(err, ShouldBeNil)
+		edPrivateKey, _, err := crypt.GenerateEd25519KeyStr()
+		So(err, ShouldBeNil)
+
+		for alg, key := range map[string]string{
+			crypt.JWSRS256: privateKey, crypt.JWSES256: ecPrivateKey, crypt.JWSEdDSA: edPrivateKey,
+		} {
+			issuer, err := NewJWTIssuer(key, "saas", "tenant1")
+			So(err, ShouldBeNil)
+			token, err := issuer.Issue("Tencent", "AppID1", "AppID1Scope")
+			So(err, ShouldBeNil)
+			header, err := crypt.ParseJWSHeader(token)
+			So(err, ShouldBeNil)
+			So(header.Alg, ShouldEqual, alg)
+
+			claims, err := issuer.Parse(token)
+			So(err, ShouldBeNil)
+			So(claims.Subject, ShouldEqual, "Tencent")
+			So(claims.AppID, ShouldEqual, "AppID1")
+			So(claims.Tenant, ShouldEqual, "tenant1")
+			So(claims.Scope, ShouldEqual, "AppID1Scope")
+			So(claims.ExpiresAt-claims.IssuedAt, ShouldEqual, int64(TokenExpiry/time.Second))
+
+			// 其他租户的签发者不能校验
+			other, err := NewJWTIssuer(key, "saas", "tenant2")
+			So(err, ShouldBeNil)
+			_, err = other.Parse(token)
+			So(err, ShouldBeError)
+
+			jwks, err := issuer.JWKS()
+			So(err, ShouldBeNil)
+			So(jwks.Get(header.Kid), ShouldNotBeNil)
+			So(jwks.Keys[0].Alg, ShouldEqual, alg)
+		}
+	})
+
+	Convey("Expired&Revoked", t, func() {
+		issuer, err := NewJWTIssuer(privateKey, "saas", "")
+		So(err, ShouldBeNil)
+		issuer.SetExpiresIn(-time.Minute)
+		token, err := issuer.Issue("Tencent", "AppID1", "")
+		So(err, ShouldBeNil)
+		_, err = issuer.Parse(token)
+		So(err, ShouldBeError)
+
+		issuer.SetExpiresIn(TokenExpiry)
+		token, err = issuer.Issue("Tencent", "AppID1", "")
+		So(err, ShouldBeNil)
+		claims, err := issuer.Parse(token)
+		So(err, ShouldBeNil)
+		So(issuer.Revoke(claims), ShouldBeNil)
+		_, err = issuer.Parse(token)
+		So(err, ShouldEqual, ErrTokenRevoked)
+
+		// 篡改声明
+		token, err = issuer.Issue("Tencent", "AppID1", "")
+		So(err, ShouldBeNil)
+		parts := strings.Split(token, ".")
+		payload, _ := json.Marshal(&JWTClaims{ID: "x", Subject: "Other", AppID: "AppID1",
+			ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+		_, err = issuer.Parse(strings.Join(parts, "."))
+		So(err, ShouldEqual, crypt.ErrSignMismatch)
+	})
+
+	Convey("KeyManager rotation", t, func() {
+		km, err := crypt.NewLocalKeyManager(filepath.Join(t.TempDir(), "keys.db"), []byte("E15E2A40282E42E01163B2643C208505"))
+		So(err, ShouldBeNil)
+		keyID1, err := km.CreateKey("jwt-sign", crypt.KeyPurposeSign)
+		So(err, ShouldBeNil)
+		_, err = km.CreateKey("other-sign", crypt.KeyPurposeSign)
+		So(err, ShouldBeNil)
+		_, err = km.CreateKey("data", crypt.KeyPurposeEncrypt)
+		So(err, ShouldBeNil)
+		_, err = NewJWTIssuerWithKeyManager(km, "data", "saas", "")
+		So(err, ShouldEqual, crypt.ErrKeyPurpose)
+		issuer, err := NewJWTIssuerWithKeyManager(km, "jwt-sign", "saas", "")
+		So(err, ShouldBeNil)
+
+		token1, err := issuer.Issue("Tencent", "AppID1", "")
+		So(err, ShouldBeNil)
+		header, err := crypt.ParseJWSHeader(token1)
+		So(err, ShouldBeNil)
+		So(header.Kid, ShouldEqual, keyID1)
+
+		// 轮换后新JWT使用新版本，老JWT仍可校验，JWKS包含两个版本
+		keyID2, err := km.Rotate("jwt-sign")
+		So(err, ShouldBeNil)
+		token2, err := issuer.Issue("Tencent", "AppID1", "")
+		So(err, ShouldBeNil)
+		header, err = crypt.ParseJWSHeader(token2)
+		So(err, ShouldBeNil)
+		So(header.Kid, ShouldEqual, keyID2)
+		_, err = issuer.Parse(token1)
+		So(err, ShouldBeNil)
+		_, err = issuer.Parse(token2)
+		So(err, ShouldBeNil)
+		jwks, err := issuer.JWKS()
+		So(err, ShouldBeNil)
+		So(jwks.Keys, ShouldHaveLength, 2)
+		So(jwks.Get(keyID1), ShouldNotBeNil)
+		So(jwks.Get(keyID2).Use, ShouldEqual, "sig")
+
+		// 其他签名密钥签发的JWT不被接受
+		other, err := NewJWTIssuerWithKeyManager(km, "other-sign", "saas", "")
+		So(err, ShouldBeNil)
+		token3, err := other.Issue("Tencent", "AppID1", "")
+		So(err, ShouldBeNil)
+		_, err = issuer.Parse(token3)
+		So(err, ShouldBeError)
+
+		// 版本退役后由其签发的JWT失效
+		So(km.Retire(keyID1), ShouldBeNil)
+		_, err = issuer.Parse(token1)
+		So(err, ShouldEqual, crypt.ErrKeyRetired)
+		_, err = issuer.Parse(token2)
+		So(err, ShouldBeNil)
+		jwks, err = issuer.JWKS()
+		So(err, ShouldBeNil)
+		So(jwks.Keys, ShouldHaveLength, 1)
+	})
+
+	Convey("OAuth with JWT mode", t, func() {
+		merchant := NewMerchant("Tencent", publicKey)
+		merchant.AddApp(&Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "AppID1Scope", AppName: "AppID1Name"})
+		merchant.AddApp(&Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "AppID2Scope", AppName: "AppID2Name"})
+		oauth := NewOAuth(NewBackendMerchantDB(), NewBackendTokenDB())
+		oauth.MerchantDB().Create(merchant)
+		issuer, err := NewJWTIssuer(privateKey, "saas", "")
+		So(err, ShouldBeNil)
+		oauth.SetJWTIssuer(issuer)
+		So(oauth.JWTIssuer(), ShouldEqual, issuer)
+
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+		targetSign, err := SignMerchantInfo(privateKey, mInfo)
+		So(err, ShouldBeNil)
+		token, err := oauth.IssueToken(mInfo, targetSign)
+		So(err, ShouldBeNil)
+		accessToken := token.AccessToken
+		So(strings.Count(accessToken, "."), ShouldEqual, 2)
+		So(VerifyTokenChecksum(token.RefreshToken), ShouldBeTrue)
+		So(oauth.VerifyToken(mInfo, accessToken), ShouldBeNil)
+		So(oauth.VerifyToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID2"}, accessToken), ShouldBeError)
+
+		token, err = oauth.RefreshToken(mInfo, token.RefreshToken)
+		So(err, ShouldBeNil)
+		newAccessToken := token.AccessToken
+		So(newAccessToken, ShouldNotEqual, accessToken)
+		So(oauth.VerifyToken(mInfo, newAccessToken), ShouldBeNil)
+
+		// 校验无需访问MerchantDB
+		So(oauth.MerchantDB().Delete("Tencent"), ShouldBeNil)
+		So(oauth.VerifyToken(mInfo, newAccessToken), ShouldBeNil)
+		So(oauth.MerchantDB().Create(merchant), ShouldBeNil)
+
+		So(oauth.RevokeToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID2"}, newAccessToken), ShouldBeError)
+		So(oauth.RevokeToken(mInfo, newAccessToken), ShouldBeNil)
+		So(oauth.VerifyToken(mInfo, newAccessToken), ShouldEqual, ErrTokenRevoked)
+	})
+
+	Convey("JWT mode family revocation", t, func() {
+		o := newTestOAuth()
+		issuer, err := NewJWTIssuer(privateKey, "saas", "tenant1")
+		So(err, ShouldBeNil)
+		o.SetJWTIssuer(issuer)
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+		token1, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		token2, err := o.RefreshToken(mInfo, token1.RefreshToken)
+		So(err, ShouldBeNil)
+		other, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		So(o.VerifyToken(mInfo, token1.AccessToken), ShouldBeNil)
+		So(o.VerifyToken(mInfo, token2.AccessToken), ShouldBeNil)
+
+		// 重用已轮换的refreshtoken，家族已签发的JWT一并失效
+		_, err = o.RefreshToken(mInfo, token1.RefreshToken)
+		So(err, ShouldEqual, ErrRefreshTokenReused)
+		So(o.VerifyToken(mInfo, token1.AccessToken), ShouldEqual, ErrTokenRevoked)
+		So(o.VerifyToken(mInfo, token2.AccessToken), ShouldEqual, ErrTokenRevoked)
+		result, err := o.Introspect(token2.AccessToken, "")
+		So(err, ShouldBeNil)
+		So(result.Active, ShouldBeFalse)
+		So(o.VerifyToken(mInfo, other.AccessToken), ShouldBeNil)
+
+		// 吊销refreshtoken同样吊销家族的JWT
+		So(o.Revoke(mInfo, other.RefreshToken, TokenHintRefresh), ShouldBeNil)
+		So(o.VerifyToken(mInfo, other.AccessToken), ShouldEqual, ErrTokenRevoked)
+	})
+}
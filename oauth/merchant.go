@@ -3,19 +3,78 @@ package oauth
 import (
 	"encoding/json"
 	"fmt"
+	"saas/crypt"
 )
 
 // Application 定义App
 type Application struct {
-	AppID     string `json:"app_id"`
-	AppSecret string `json:"app_secret"`
-	Scope     string `json:"scope"`
-	AppName   string `json:"app_name"`
+	AppID string `json:"app_id"`
+	// AppSecret的哈希，由SetAppSecret设置，为空时App为公开客户端
+	AppSecretHash string `json:"app_secret_hash,omitempty"`
+	Scope         string `json:"scope"`
+	AppName       string `json:"app_name"`
+	// 授权码模式允许的回调地址，必须完全匹配
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	// Token生存期策略，为nil时使用租户默认策略，见OAuth.SetTokenPolicy
+	TokenPolicy *TokenPolicy `json:"token_policy,omitempty"`
 }
 
-// String 格式化输出
+// HasRedirectURI 判断回调地址是否已登记
+func (a *Application) HasRedirectURI(redirectURI string) bool {
+	for _, uri := range a.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// appSecretParams AppSecret的哈希参数。AppSecret由GenerateAppSecret生成，熵足够高，
+// 无需口令哈希的高代价参数；认证请求无需登录即可触发校验，低代价参数也避免了内存与CPU耗尽
+var appSecretParams = &crypt.PasswordParams{
+	Algorithm:  crypt.PasswordPBKDF2SHA256,
+	Iterations: 1000,
+	SaltLen:    16,
+	KeyLen:     32,
+}
+
+// GenerateAppSecret 使用TokenGenerator生成新的AppSecret并保存其哈希，返回的明文只能在此时获取
+func (a *Application) GenerateAppSecret() (string, error) {
+	secret, err := NewTokenGenerator().Generate(TokenTypeAppSecret)
+	if err != nil {
+		return "", err
+	}
+	if err = a.SetAppSecret(secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// SetAppSecret 设置AppSecret，只保存其哈希，secret为空时清除。secret应由GenerateAppSecret生成
+func (a *Application) SetAppSecret(secret string) error {
+	if secret == "" {
+		a.AppSecretHash = ""
+		return nil
+	}
+	hash, err := crypt.HashPassword(secret, appSecretParams)
+	if err != nil {
+		return err
+	}
+	a.AppSecretHash = hash
+	return nil
+}
+
+// VerifyAppSecret 校验AppSecret，未设置AppSecret时返回crypt.ErrPasswordMismatch
+func (a *Application) VerifyAppSecret(secret string) error {
+	if a.AppSecretHash == "" {
+		return crypt.ErrPasswordMismatch
+	}
+	return crypt.VerifyPassword(secret, a.AppSecretHash)
+}
+
+// String 格式化输出，不包含AppSecret
 func (a *Application) String() string {
-	return fmt.Sprintf("AppID:%s, AppSecret:%s,Scope:%s,AppName:%s", a.AppID, a.AppSecret, a.Scope, a.AppName)
+	return fmt.Sprintf("AppID:%s,Scope:%s,AppName:%s", a.AppID, a.Scope, a.AppName)
 }
 
 // Merchant 定义商户
@@ -1,6 +1,7 @@
 package oauth
 
 import (
+	"encoding/json"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -15,8 +16,8 @@ func Test(t *testing.T) {
 		merchant.SetKey(pubKey)
 		k := merchant.GetKey()
 		So(k, ShouldEqual, pubKey)
-		app1 := &Application{"AppID1", "AppID1Secret", "AppID1Scope", "AppID1Name"}
-		app2 := &Application{"AppID2", "AppID2Secret", "AppID2Scope", "AppID2Name"}
+		app1 := &Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "AppID1Scope", AppName: "AppID1Name"}
+		app2 := &Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "AppID2Scope", AppName: "AppID2Name"}
 		err := merchant.AddApp(app1)
 		So(err, ShouldBeTrue)
 		err = merchant.AddApp(app1)
@@ -35,4 +36,27 @@ func Test(t *testing.T) {
 		t.Logf("%+v\n%s", merchant, merchant.Prettify())
 	})
 
+	Convey("AppSecret只保存哈希", t, func() {
+		app := &Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"}
+		So(app.VerifyAppSecret(""), ShouldNotBeNil)
+		So(app.SetAppSecret("AppID1Secret"), ShouldBeNil)
+		So(app.AppSecretHash, ShouldStartWith, "$pbkdf2-sha256$")
+		So(app.VerifyAppSecret("AppID1Secret"), ShouldBeNil)
+		So(app.VerifyAppSecret("AppID2Secret"), ShouldNotBeNil)
+
+		buf, err := json.Marshal(app)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldNotContainSubstring, "AppID1Secret")
+		So(app.String(), ShouldNotContainSubstring, "AppID1Secret")
+
+		secret, err := app.GenerateAppSecret()
+		So(err, ShouldBeNil)
+		So(VerifyTokenChecksum(secret), ShouldBeTrue)
+		So(app.VerifyAppSecret(secret), ShouldBeNil)
+		So(app.VerifyAppSecret("AppID1Secret"), ShouldNotBeNil)
+
+		So(app.SetAppSecret(""), ShouldBeNil)
+		So(app.AppSecretHash, ShouldBeEmpty)
+	})
+
 }
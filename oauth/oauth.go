@@ -3,28 +3,56 @@
 // 1）参考了微信小程序后台的鉴权方式，沿用了AccessToke与RefreshToken的体系
 // 2）每个商户都有自己的公私钥对，商户在获取AccessToke的时候需要用私钥签名，以验证商户身份
 // 3）这里oauth体系是面向商户的，而不是面向终端用户的。
+// 4）商户可以通过授权码模式(PKCE)授权自己的第三方工具代为访问，授权的主体仍然是商户。
 package oauth
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"saas/crypt"
+	"sync"
+	"time"
 )
 
 // MerchantInfo 将多个参数打包成一个结构体，方便参数传递
 type MerchantInfo struct {
 	MerchantID string
 	AppID      string
+	// 获取accesstoken时参与签名，用于防重放
+	Timestamp int64  // Unix时间戳，单位秒
+	Nonce     string // 随机串，在允许的时钟偏差窗口内不可重复
+	Scope     string // 申请的scope，以空格分隔，为空时授予App允许的全部scope
+}
+
+// payload 返回参与签名的字符串：merchantID:appID:timestamp:nonce:scope，scope经过URL转义
+func (m *MerchantInfo) payload() string {
+	return fmt.Sprintf("%s:%s:%d:%s:%s", m.MerchantID, m.AppID, m.Timestamp, m.Nonce, url.QueryEscape(m.Scope))
 }
 
 // OAuth 主结构体，封装商户数据与Token数据
 type OAuth struct {
 	merchantDB MerchantDB
 	tokenDB    TokenDB
+	reqVerify  *crypt.RequestVerifier
+	jwtIssuer  *JWTIssuer // 不为nil时签发JWT格式的accesstoken
+	tenant     string     // 所属租户，用于Token内省
+	assertAud  string     // private_key_jwt客户端断言要求的aud，为空时不接受客户端断言
+	codeDB     CodeDB
+	consent    ConsentFunc             // 授权码模式的授权同意回调，为nil时不支持授权码模式
+	policies   map[string]*TokenPolicy // 租户的Token策略，key:appID，空字符串为租户默认策略
+	policyLock sync.RWMutex            // 保护policies，SetTokenPolicy可以与请求处理并发
 }
 
-// NewOAuth 生成OAuth结构体
+// NewOAuth 生成OAuth结构体，授权码默认存储在BackendCodeDB中
 func NewOAuth(mdb MerchantDB, tdb TokenDB) *OAuth {
-	return &OAuth{mdb, tdb}
+	return &OAuth{
+		merchantDB: mdb,
+		tokenDB:    tdb,
+		reqVerify:  crypt.NewRequestVerifier(),
+		codeDB:     NewBackendCodeDB(),
+		policies:   map[string]*TokenPolicy{},
+	}
 }
 
 // MerchantDB 返回OAuth中的merchantDB实例
@@ -37,48 +65,187 @@ func (o *OAuth) TokenDB() TokenDB {
 	return o.tokenDB
 }
 
-// GetAccessToken 商户获取某个App对应的accesstoken
+// RequestVerifier 返回OAuth中用于商户请求验签的RequestVerifier实例，可以修改时钟偏差与NonceStore
+func (o *OAuth) RequestVerifier() *crypt.RequestVerifier {
+	return o.reqVerify
+}
+
+// SetNonceStore 设置防重放使用的NonceStore，默认为crypt.MemoryNonceStore；
+// 多实例部署时应使用共享存储实现
+func (o *OAuth) SetNonceStore(store crypt.NonceStore) {
+	o.reqVerify.Nonces = store
+}
+
+// SetTenant 设置所属租户
+func (o *OAuth) SetTenant(tenant string) {
+	o.tenant = tenant
+}
+
+// JWTIssuer 返回OAuth中的jwtIssuer实例，未启用JWT模式时为nil
+func (o *OAuth) JWTIssuer() *JWTIssuer {
+	return o.jwtIssuer
+}
+
+// SetJWTIssuer 设置JWT签发者，启用JWT模式：accesstoken为自包含的JWT，VerifyToken无需访问TokenDB，
+// RevokeToken通过吊销列表实现。为nil时使用TokenDB中的不透明Token
+func (o *OAuth) SetJWTIssuer(issuer *JWTIssuer) {
+	o.jwtIssuer = issuer
+}
+
+// GetAccessToken 商户获取某个App对应的accesstoken，同IssueToken，只返回accesstoken
 func (o *OAuth) GetAccessToken(mInfo *MerchantInfo, targetSign string) (string, error) {
-	merchant, err := o.merchantDB.Read(mInfo.MerchantID)
+	token, err := o.IssueToken(mInfo, targetSign)
 	if err != nil {
 		return "", err
 	}
-	if err = VerifyMerchantInfo(merchant.GetKey(), mInfo, targetSign); err != nil {
-		return "", err
+	return token.AccessToken, nil
+}
+
+// IssueToken 商户获取某个App对应的accesstoken与refreshtoken。
+// 签名需由SignMerchantInfo生成，时间戳超出允许偏差时返回crypt.ErrRequestExpired，
+// nonce重复使用时返回crypt.ErrNonceReused，申请的scope超出App允许的范围时返回ErrInvalidScope
+func (o *OAuth) IssueToken(mInfo *MerchantInfo, targetSign string) (*Token, error) {
+	merchant, err := o.merchantDB.Read(mInfo.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	err = o.reqVerify.VerifyMessage(merchant.GetKey(), mInfo.payload(), mInfo.Timestamp, mInfo.Nonce, targetSign)
+	if err != nil {
+		return nil, err
 	}
 	if !merchant.HasApp(mInfo.AppID) {
-		return "", fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
+		return nil, fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
 	}
+	return o.issue(merchant, mInfo)
+}
+
+// issue 为已认证的商户App签发Token，申请的scope收窄到App允许的范围
+func (o *OAuth) issue(merchant *Merchant, mInfo *MerchantInfo) (*Token, error) {
 	app := merchant.GetApp(mInfo.AppID)
-	token, err := o.tokenDB.CreateToken(app.AppID, app.AppSecret)
-	return token.AccessToken, err
+	scopes, err := NarrowScopes(ParseScopes(app.Scope), ParseScopes(mInfo.Scope))
+	if err != nil {
+		return nil, err
+	}
+	policy := o.tokenPolicy(app)
+	token, err := o.tokenDB.CreateToken(merchant.MerchantID, app.AppID, scopes.String(), policy)
+	if err != nil {
+		return nil, err
+	}
+	return o.withJWT(mInfo, token, policy)
 }
 
-// RefreshToken 商户对某个App对应的accesstoken进行续期操作
-func (o *OAuth) RefreshToken(mInfo *MerchantInfo, accessToken string) (string, error) {
+// RefreshToken 商户使用refreshtoken为某个App换取新的accesstoken与refreshtoken，accesstoken过期后仍可使用。
+// 每次刷新都会轮换refreshtoken，老的refreshtoken被再次使用时吊销整个Token家族并返回ErrRefreshTokenReused，
+// JWT模式下家族已签发的JWT一并吊销
+func (o *OAuth) RefreshToken(mInfo *MerchantInfo, refreshToken string) (*Token, error) {
 	merchant, err := o.merchantDB.Read(mInfo.MerchantID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if !merchant.HasApp(mInfo.AppID) {
-		return "", fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
+		return nil, fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
+	}
+	policy := o.tokenPolicy(merchant.GetApp(mInfo.AppID))
+	token, err := o.tokenDB.RefreshToken(mInfo.MerchantID, mInfo.AppID, refreshToken, policy)
+	if err == ErrRefreshTokenReused && token != nil && o.jwtIssuer != nil {
+		if revokeErr := o.jwtIssuer.RevokeFamily(token.GetFamilyID(), o.jwtExpiresIn(policy)); revokeErr != nil {
+			return nil, revokeErr
+		}
 	}
-	return o.tokenDB.RefreshToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return o.withJWT(mInfo, token, policy)
 }
 
-// VerifyToken 验证商户的某个App对应的accesstoken是否有效
+// withJWT JWT模式下将accesstoken替换为JWT，TokenDB只用于refreshtoken。
+// JWT有效期取policy.AccessTTL，未设置时为JWTIssuer的有效期，且不超出家族的AbsoluteLifetime
+func (o *OAuth) withJWT(mInfo *MerchantInfo, token *Token, policy *TokenPolicy) (*Token, error) {
+	if o.jwtIssuer == nil {
+		return token, nil
+	}
+	expiresIn := policy.capLifetime(token.GetFamilyCreateAt(), time.Now(), o.jwtExpiresIn(policy))
+	accessToken, err := o.jwtIssuer.issue(mInfo.MerchantID, token.GetAppID(), token.GetScope(), token.GetFamilyID(), expiresIn)
+	if err != nil {
+		return nil, err
+	}
+	token.SetAccessToken(accessToken)
+	token.SetAccessExpiresIn(expiresIn)
+	return token, nil
+}
+
+// jwtExpiresIn 返回JWT的有效期，取policy.AccessTTL，未设置时为JWTIssuer的有效期
+func (o *OAuth) jwtExpiresIn(policy *TokenPolicy) time.Duration {
+	if policy != nil && policy.AccessTTL > 0 {
+		return policy.AccessTTL
+	}
+	return o.jwtIssuer.expiresIn
+}
+
+// revokeFamily 吊销Token家族，JWT模式下家族已签发的JWT一并吊销，policy为App生效的Token策略
+func (o *OAuth) revokeFamily(familyID string, policy *TokenPolicy) error {
+	if err := o.tokenDB.RevokeFamily(familyID); err != nil {
+		return err
+	}
+	if o.jwtIssuer == nil {
+		return nil
+	}
+	return o.jwtIssuer.RevokeFamily(familyID, o.jwtExpiresIn(policy))
+}
+
+// VerifyToken 验证商户的某个App对应的accesstoken是否有效。
+// JWT模式下只校验签名、有效期、吊销状态与归属，不访问MerchantDB与TokenDB
 func (o *OAuth) VerifyToken(mInfo *MerchantInfo, accessToken string) error {
+	_, err := o.verifyToken(mInfo, accessToken)
+	return err
+}
+
+// VerifyTokenScopes 验证accesstoken是否有效且具备required中的全部scope，返回授予的scope。
+// 缺少scope时返回ErrInsufficientScope
+func (o *OAuth) VerifyTokenScopes(mInfo *MerchantInfo, accessToken string, required ...string) (Scopes, error) {
+	granted, err := o.verifyToken(mInfo, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if !granted.AllowsAll(required) {
+		return granted, ErrInsufficientScope
+	}
+	return granted, nil
+}
+
+// verifyToken 验证accesstoken并返回授予的scope
+func (o *OAuth) verifyToken(mInfo *MerchantInfo, accessToken string) (Scopes, error) {
+	if o.jwtIssuer != nil {
+		claims, err := o.jwtIssuer.Parse(accessToken)
+		if err != nil {
+			return nil, err
+		}
+		if err = claims.checkOwner(mInfo); err != nil {
+			return nil, err
+		}
+		return ParseScopes(claims.Scope), nil
+	}
 	merchant, err := o.merchantDB.Read(mInfo.MerchantID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !merchant.HasApp(mInfo.AppID) {
-		return fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
+		return nil, fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
+	}
+	if err = o.tokenDB.VerifyToken(accessToken); err != nil {
+		return nil, err
+	}
+	token, err := o.tokenDB.GetToken(accessToken)
+	if err != nil {
+		return nil, err
 	}
-	return o.tokenDB.VerifyToken(accessToken)
+	if token.GetMerchantID() != mInfo.MerchantID || token.GetAppID() != mInfo.AppID {
+		return nil, fmt.Errorf("accessToken not belong to merchant(%s) app(%s)", mInfo.MerchantID, mInfo.AppID)
+	}
+	return ParseScopes(token.GetScope()), nil
 }
 
-// RevokeToken 将商户的某个App对应的accesstoken设置为无效
+// RevokeToken 将商户的某个App对应的accesstoken设置为无效，accesstoken不属于该商户App时返回错误
 func (o *OAuth) RevokeToken(mInfo *MerchantInfo, accessToken string) error {
 	merchant, err := o.merchantDB.Read(mInfo.MerchantID)
 	if err != nil {
@@ -87,17 +254,63 @@ func (o *OAuth) RevokeToken(mInfo *MerchantInfo, accessToken string) error {
 	if !merchant.HasApp(mInfo.AppID) {
 		return fmt.Errorf("merchant(%s) do not have app(%s)", mInfo.MerchantID, mInfo.AppID)
 	}
+	if o.jwtIssuer != nil {
+		claims, err := o.jwtIssuer.parse(accessToken)
+		if err != nil {
+			return err
+		}
+		if err = claims.checkOwner(mInfo); err != nil {
+			return err
+		}
+		return o.jwtIssuer.Revoke(claims)
+	}
+	token, err := o.tokenDB.GetToken(accessToken)
+	if err != nil {
+		return err
+	}
+	if token.GetMerchantID() != mInfo.MerchantID || token.GetAppID() != mInfo.AppID {
+		return fmt.Errorf("accessToken not belong to merchant(%s) app(%s)", mInfo.MerchantID, mInfo.AppID)
+	}
 	return o.tokenDB.DeleteToken(accessToken)
 }
 
-// SignMerchantInfo 对商户的请求信息进行签名。采用非对称加密算法。
+// SignMerchantInfo 对商户的请求信息进行签名。采用非对称加密算法，支持RSA、ECDSA、Ed25519私钥。
+// 签名内容为merchantID:appID:timestamp:nonce:scope，Timestamp或Nonce为空时会填入当前时间与随机nonce
 func SignMerchantInfo(privateKey string, info *MerchantInfo) (string, error) {
-	plaintext := fmt.Sprintf("%s:%s", info.MerchantID, info.AppID)
-	return crypt.SignSha256WithRsa(privateKey, plaintext)
+	if info.Timestamp == 0 {
+		info.Timestamp = time.Now().Unix()
+	}
+	if info.Nonce == "" {
+		nonce, err := crypt.RandomNonce()
+		if err != nil {
+			return "", err
+		}
+		info.Nonce = nonce
+	}
+	return crypt.SignWithKey(privateKey, info.payload())
 }
 
-// VerifyMerchantInfo 对商户的请求信息进行验签。采用非对称加密算法。
+// VerifyMerchantInfo 对商户的请求信息进行验签。根据商户公钥的类型选择验签算法。
+// 只校验签名，不校验时间戳与nonce，防重放校验见OAuth.GetAccessToken
 func VerifyMerchantInfo(publicKey string, minfo *MerchantInfo, targetSign string) error {
-	plaintext := fmt.Sprintf("%s:%s", minfo.MerchantID, minfo.AppID)
-	return crypt.VerifySignSha256WithRsa(publicKey, plaintext, targetSign)
+	return crypt.VerifyWithKey(publicKey, minfo.payload(), targetSign)
+}
+
+// VerifyMerchantRequest 使用商户公钥校验按crypt.CanonicalRequest规范签名的请求，
+// 同时校验时钟偏差与nonce唯一性
+func (o *OAuth) VerifyMerchantRequest(merchantID string, req *crypt.CanonicalRequest, sign string) error {
+	merchant, err := o.merchantDB.Read(merchantID)
+	if err != nil {
+		return err
+	}
+	return o.reqVerify.Verify(merchant.GetKey(), req, sign)
+}
+
+// VerifyMerchantHTTPRequest 校验商户用crypt.SignHTTPRequest签名的HTTP请求
+func (o *OAuth) VerifyMerchantHTTPRequest(merchantID string, r *http.Request) error {
+	merchant, err := o.merchantDB.Read(merchantID)
+	if err != nil {
+		return err
+	}
+	return o.reqVerify.VerifyHTTP(merchant.GetKey(), r)
 }
@@ -1,7 +1,13 @@
 package oauth
 
 import (
+	"crypto/elliptic"
+	"net/http/httptest"
+	"path/filepath"
+	"saas/crypt"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
@@ -45,11 +51,20 @@ PZAu+w4TqBl+uZQJlSzui2K1nuPbWeNpqatS5MVMK2rjJ3glEtNqnQUScVIL5MWk
 4QIDAQAB
 -----END PUBLIC KEY-----`
 
+// testSecretHash 返回AppSecret的哈希，供测试用例登记App
+func testSecretHash(secret string) string {
+	app := &Application{}
+	if err := app.SetAppSecret(secret); err != nil {
+		panic(err)
+	}
+	return app.AppSecretHash
+}
+
 func TestOAuth(t *testing.T) {
 
 	Convey("SignMerchantInfo&VerifyMerchantInfo", t, func() {
 
-		mInfo := &MerchantInfo{"Tencent", "TXSP"}
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "TXSP"}
 
 		targetSign, err := SignMerchantInfo(privateKey, mInfo)
 		So(err, ShouldBeNil)
@@ -60,11 +75,31 @@ func TestOAuth(t *testing.T) {
 
 	})
 
+	Convey("SignMerchantInfo&VerifyMerchantInfo with EC and Ed25519 keys", t, func() {
+
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "TXSP"}
+		ecPrivateKey, ecPublicKey, err := crypt.GenerateECDSAKeyStr(elliptic.P256())
+		So(err, ShouldBeNil)
+		edPrivateKey, edPublicKey, err := crypt.GenerateEd25519KeyStr()
+		So(err, ShouldBeNil)
+
+		targetSign, err := SignMerchantInfo(ecPrivateKey, mInfo)
+		So(err, ShouldBeNil)
+		So(VerifyMerchantInfo(ecPublicKey, mInfo, targetSign), ShouldBeNil)
+		So(VerifyMerchantInfo(edPublicKey, mInfo, targetSign), ShouldBeError)
+
+		targetSign, err = SignMerchantInfo(edPrivateKey, mInfo)
+		So(err, ShouldBeNil)
+		So(VerifyMerchantInfo(edPublicKey, mInfo, targetSign), ShouldBeNil)
+		So(VerifyMerchantInfo(publicKey, mInfo, targetSign), ShouldBeError)
+
+	})
+
 	Convey("OAuth", t, func() {
 
 		merchant := NewMerchant("Tencent", publicKey)
-		app1 := &Application{"AppID1", "AppID1Secret", "AppID1Scope", "AppID1Name"}
-		app2 := &Application{"AppID2", "AppID2Secret", "AppID2Scope", "AppID2Name"}
+		app1 := &Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "AppID1Scope", AppName: "AppID1Name"}
+		app2 := &Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "AppID2Scope", AppName: "AppID2Name"}
 		ok := merchant.AddApp(app1)
 		So(ok, ShouldBeTrue)
 		ok = merchant.AddApp(app2)
@@ -75,22 +110,212 @@ func TestOAuth(t *testing.T) {
 		oauth := NewOAuth(mdb, tdb)
 		oauth.MerchantDB().Create(merchant)
 
-		mInfo := &MerchantInfo{"Tencent", "AppID1"}
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
 		targetSign, err := SignMerchantInfo(privateKey, mInfo)
 		So(err, ShouldBeNil)
-		accessToken, err := oauth.GetAccessToken(mInfo, targetSign)
+		token, err := oauth.IssueToken(mInfo, targetSign)
 		So(err, ShouldBeNil)
+		accessToken := token.AccessToken
 		t.Logf("accessToken:%s", accessToken)
-		newAccessToken, err := oauth.RefreshToken(mInfo, accessToken)
+		_, err = oauth.GetAccessToken(mInfo, targetSign)
+		So(err, ShouldEqual, crypt.ErrNonceReused) // 重放
+		token, err = oauth.RefreshToken(mInfo, token.RefreshToken)
 		So(err, ShouldBeNil)
+		newAccessToken := token.AccessToken
 		err = oauth.VerifyToken(mInfo, accessToken)
 		So(err, ShouldBeError) // 刷新后老accessToken失效
 		err = oauth.VerifyToken(mInfo, newAccessToken)
 		So(err, ShouldBeNil)
+		// 不能吊销其他App的accessToken
+		err = oauth.RevokeToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID2"}, newAccessToken)
+		So(err, ShouldBeError)
+		So(oauth.VerifyToken(mInfo, newAccessToken), ShouldBeNil)
+		// 其他商户登记了相同的AppID也不能使用该accessToken，创建后再添加App以绕过AppID唯一性校验
+		other := NewMerchant("Alibaba", publicKey)
+		So(oauth.MerchantDB().Create(other), ShouldBeNil)
+		other.AddApp(&Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		err = oauth.VerifyToken(&MerchantInfo{MerchantID: "Alibaba", AppID: "AppID1"}, newAccessToken)
+		So(err, ShouldBeError)
 		err = oauth.RevokeToken(mInfo, newAccessToken)
 		So(err, ShouldBeNil)
 		err = oauth.VerifyToken(mInfo, newAccessToken)
 		So(err, ShouldBeError)
 
 	})
+
+	Convey("RefreshToken rotation and reuse detection", t, func() {
+
+		merchant := NewMerchant("Tencent", publicKey)
+		merchant.AddApp(&Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "AppID1Scope", AppName: "AppID1Name"})
+		merchant.AddApp(&Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "AppID2Scope", AppName: "AppID2Name"})
+		tdb := NewBackendTokenDB()
+		oauth := NewOAuth(NewBackendMerchantDB(), tdb)
+		oauth.MerchantDB().Create(merchant)
+
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+		targetSign, err := SignMerchantInfo(privateKey, mInfo)
+		So(err, ShouldBeNil)
+		token1, err := oauth.IssueToken(mInfo, targetSign)
+		So(err, ShouldBeNil)
+
+		// accessToken过期后仍可刷新
+		stored, err := tdb.GetToken(token1.AccessToken)
+		So(err, ShouldBeNil)
+		stored.SetAccessCreateAt(time.Now().Add(-TokenExpiry * 2))
+		So(oauth.VerifyToken(mInfo, token1.AccessToken), ShouldBeError)
+
+		// 其他App不能使用该refreshtoken
+		_, err = oauth.RefreshToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID2"}, token1.RefreshToken)
+		So(err, ShouldBeError)
+		// 其他商户登记了相同的AppID也不能使用，创建后再添加App以绕过AppID唯一性校验
+		otherMerchant := NewMerchant("Alibaba", publicKey)
+		So(oauth.MerchantDB().Create(otherMerchant), ShouldBeNil)
+		otherMerchant.AddApp(&Application{AppID: "AppID1", Scope: "AppID1Scope", AppName: "AppID1Name"})
+		_, err = oauth.RefreshToken(&MerchantInfo{MerchantID: "Alibaba", AppID: "AppID1"}, token1.RefreshToken)
+		So(err, ShouldBeError)
+		So(oauth.MerchantDB().Delete("Alibaba"), ShouldBeNil)
+
+		token2, err := oauth.RefreshToken(mInfo, token1.RefreshToken)
+		So(err, ShouldBeNil)
+		So(token2.RefreshToken, ShouldNotEqual, token1.RefreshToken)
+		So(token2.FamilyID, ShouldEqual, token1.FamilyID)
+		So(oauth.VerifyToken(mInfo, token2.AccessToken), ShouldBeNil)
+		token3, err := oauth.RefreshToken(mInfo, token2.RefreshToken)
+		So(err, ShouldBeNil)
+
+		// 重用已轮换的refreshtoken，整个家族被吊销
+		_, err = oauth.RefreshToken(mInfo, token1.RefreshToken)
+		So(err, ShouldEqual, ErrRefreshTokenReused)
+		So(oauth.VerifyToken(mInfo, token3.AccessToken), ShouldBeError)
+		_, err = oauth.RefreshToken(mInfo, token3.RefreshToken)
+		So(err, ShouldBeError)
+
+		// 不影响其他家族
+		mInfo2 := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+		targetSign, err = SignMerchantInfo(privateKey, mInfo2)
+		So(err, ShouldBeNil)
+		other, err := oauth.IssueToken(mInfo2, targetSign)
+		So(err, ShouldBeNil)
+		_, err = oauth.RefreshToken(mInfo, token2.RefreshToken)
+		So(err, ShouldEqual, ErrRefreshTokenReused)
+		So(oauth.VerifyToken(mInfo, other.AccessToken), ShouldBeNil)
+
+	})
+
+	Convey("Scoped tokens", t, func() {
+
+		merchant := NewMerchant("Tencent", publicKey)
+		merchant.AddApp(&Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "orders users:read", AppName: "AppID1Name"})
+		merchant.AddApp(&Application{AppID: "AppID2", AppSecretHash: testSecretHash("AppID2Secret"), Scope: "orders", AppName: "AppID2Name"})
+		tdb := NewBackendTokenDB()
+		oauth := NewOAuth(NewBackendMerchantDB(), tdb)
+		oauth.MerchantDB().Create(merchant)
+		issuer, err := NewJWTIssuer(privateKey, "saas", "")
+		So(err, ShouldBeNil)
+
+		for _, jwtIssuer := range []*JWTIssuer{nil, issuer} {
+			oauth.SetJWTIssuer(jwtIssuer)
+
+			// 超出App允许的scope
+			mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1", Scope: "orders users"}
+			targetSign, err := SignMerchantInfo(privateKey, mInfo)
+			So(err, ShouldBeNil)
+			_, err = oauth.IssueToken(mInfo, targetSign)
+			So(err, ShouldEqual, ErrInvalidScope)
+
+			// 签名绑定了scope
+			mInfo = &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1", Scope: "orders:read"}
+			targetSign, err = SignMerchantInfo(privateKey, mInfo)
+			So(err, ShouldBeNil)
+			mInfo.Scope = "orders"
+			_, err = oauth.IssueToken(mInfo, targetSign)
+			So(err, ShouldBeError)
+			mInfo.Scope = "orders:read"
+			token, err := oauth.IssueToken(mInfo, targetSign)
+			So(err, ShouldBeNil)
+			So(token.Scope, ShouldEqual, "orders:read")
+
+			granted, err := oauth.VerifyTokenScopes(mInfo, token.AccessToken, "orders:read:detail")
+			So(err, ShouldBeNil)
+			So(granted, ShouldResemble, Scopes{"orders:read"})
+			granted, err = oauth.VerifyTokenScopes(mInfo, token.AccessToken, "orders:write")
+			So(err, ShouldEqual, ErrInsufficientScope)
+			So(granted, ShouldResemble, Scopes{"orders:read"})
+
+			// 不指定scope时授予App允许的全部scope，刷新后scope不变
+			mInfo = &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+			targetSign, err = SignMerchantInfo(privateKey, mInfo)
+			So(err, ShouldBeNil)
+			token, err = oauth.IssueToken(mInfo, targetSign)
+			So(err, ShouldBeNil)
+			token, err = oauth.RefreshToken(mInfo, token.RefreshToken)
+			So(err, ShouldBeNil)
+			granted, err = oauth.VerifyTokenScopes(mInfo, token.AccessToken, "orders:write", "users:read")
+			So(err, ShouldBeNil)
+			So(granted, ShouldResemble, Scopes{"orders", "users:read"})
+
+			// 其他App不能使用该accesstoken
+			_, err = oauth.VerifyTokenScopes(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID2"}, token.AccessToken)
+			So(err, ShouldBeError)
+		}
+
+	})
+
+	Convey("VerifyMerchantRequest", t, func() {
+
+		mdb := NewBackendMerchantDB()
+		oauth := NewOAuth(mdb, NewBackendTokenDB())
+		oauth.MerchantDB().Create(NewMerchant("Tencent", publicKey))
+
+		r := httptest.NewRequest("POST", "/v1/orders?b=2&a=1", strings.NewReader(`{"amount":100}`))
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		So(oauth.VerifyMerchantHTTPRequest("Tencent", r), ShouldBeNil)
+		// 同一请求重放
+		So(oauth.VerifyMerchantHTTPRequest("Tencent", r), ShouldEqual, crypt.ErrNonceReused)
+		So(oauth.VerifyMerchantHTTPRequest("Tencent2", r), ShouldBeError)
+
+		req, err := crypt.NewCanonicalRequest("GET", "/v1/orders", nil, nil)
+		So(err, ShouldBeNil)
+		sign, err := crypt.SignRequest(privateKey, req)
+		So(err, ShouldBeNil)
+		req.Path = "/v1/refunds"
+		So(oauth.VerifyMerchantRequest("Tencent", req, sign), ShouldBeError)
+		req.Path = "/v1/orders"
+		So(oauth.VerifyMerchantRequest("Tencent", req, sign), ShouldBeNil)
+
+	})
+
+	Convey("GetAccessToken replay protection", t, func() {
+
+		merchant := NewMerchant("Tencent", publicKey)
+		merchant.AddApp(&Application{AppID: "AppID1", AppSecretHash: testSecretHash("AppID1Secret"), Scope: "AppID1Scope", AppName: "AppID1Name"})
+		oauth := NewOAuth(NewBackendMerchantDB(), NewBackendTokenDB())
+		oauth.MerchantDB().Create(merchant)
+		store, err := crypt.NewFileNonceStore(filepath.Join(t.TempDir(), "nonces.json"))
+		So(err, ShouldBeNil)
+		oauth.SetNonceStore(store)
+
+		// 过期请求
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1", Timestamp: time.Now().Add(-time.Hour).Unix()}
+		targetSign, err := SignMerchantInfo(privateKey, mInfo)
+		So(err, ShouldBeNil)
+		_, err = oauth.GetAccessToken(mInfo, targetSign)
+		So(err, ShouldEqual, crypt.ErrRequestExpired)
+
+		// 篡改nonce
+		mInfo = &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+		targetSign, err = SignMerchantInfo(privateKey, mInfo)
+		So(err, ShouldBeNil)
+		nonce := mInfo.Nonce
+		mInfo.Nonce = "other"
+		_, err = oauth.GetAccessToken(mInfo, targetSign)
+		So(err, ShouldBeError)
+
+		mInfo.Nonce = nonce
+		_, err = oauth.GetAccessToken(mInfo, targetSign)
+		So(err, ShouldBeNil)
+		_, err = oauth.GetAccessToken(mInfo, targetSign)
+		So(err, ShouldEqual, crypt.ErrNonceReused)
+
+	})
 }
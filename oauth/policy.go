@@ -0,0 +1,126 @@
+package oauth
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRefreshLimitExceeded Token家族的刷新次数已达到TokenPolicy.MaxRefreshCount
+	ErrRefreshLimitExceeded = errors.New("refresh token chain length exceeded")
+	// ErrSessionExpired Token家族已超过TokenPolicy.AbsoluteLifetime
+	ErrSessionExpired = errors.New("token session lifetime exceeded")
+	// ErrRefreshIdle refreshtoken超过TokenPolicy.IdleTimeout未使用
+	ErrRefreshIdle = errors.New("refresh token idle timeout")
+)
+
+// TokenPolicy Token生存期策略，可以挂在Application上，也可以由租户通过OAuth.SetTokenPolicy设置。
+// 字段为零值表示未设置：TTL使用TokenExpiry、RefreshExpiry，其余不限制
+type TokenPolicy struct {
+	AccessTTL        time.Duration `json:"access_ttl,omitempty"`        // accesstoken有效期
+	RefreshTTL       time.Duration `json:"refresh_ttl,omitempty"`       // refreshtoken有效期
+	MaxRefreshCount  int           `json:"max_refresh_count,omitempty"` // 同一家族最多刷新次数
+	AbsoluteLifetime time.Duration `json:"absolute_lifetime,omitempty"` // 家族自首次签发起的最长生存期，期间签发的Token不会超出
+	IdleTimeout      time.Duration `json:"idle_timeout,omitempty"`      // refreshtoken签发后超过该时长未使用则家族失效
+}
+
+// merge 返回合并后的策略，p中未设置的字段取base中的值，p与base都可以为nil
+func (p *TokenPolicy) merge(base *TokenPolicy) *TokenPolicy {
+	merged := &TokenPolicy{}
+	if base != nil {
+		*merged = *base
+	}
+	if p == nil {
+		return merged
+	}
+	if p.AccessTTL > 0 {
+		merged.AccessTTL = p.AccessTTL
+	}
+	if p.RefreshTTL > 0 {
+		merged.RefreshTTL = p.RefreshTTL
+	}
+	if p.MaxRefreshCount > 0 {
+		merged.MaxRefreshCount = p.MaxRefreshCount
+	}
+	if p.AbsoluteLifetime > 0 {
+		merged.AbsoluteLifetime = p.AbsoluteLifetime
+	}
+	if p.IdleTimeout > 0 {
+		merged.IdleTimeout = p.IdleTimeout
+	}
+	return merged
+}
+
+// Lifetimes 返回在now为家族签发Token时accesstoken与refreshtoken的有效期，
+// 不会超出家族的AbsoluteLifetime。p为nil时使用TokenExpiry与RefreshExpiry
+func (p *TokenPolicy) Lifetimes(familyCreateAt, now time.Time) (access, refresh time.Duration) {
+	access, refresh = TokenExpiry, RefreshExpiry
+	if p == nil {
+		return
+	}
+	if p.AccessTTL > 0 {
+		access = p.AccessTTL
+	}
+	if p.RefreshTTL > 0 {
+		refresh = p.RefreshTTL
+	}
+	return p.capLifetime(familyCreateAt, now, access), p.capLifetime(familyCreateAt, now, refresh)
+}
+
+// capLifetime 将有效期限制在家族的AbsoluteLifetime内
+func (p *TokenPolicy) capLifetime(familyCreateAt, now time.Time, ttl time.Duration) time.Duration {
+	if p == nil || p.AbsoluteLifetime <= 0 {
+		return ttl
+	}
+	if remain := familyCreateAt.Add(p.AbsoluteLifetime).Sub(now); remain < ttl {
+		return remain
+	}
+	return ttl
+}
+
+// CheckRefresh 校验在now使用token的refreshtoken刷新是否符合策略，p为nil时不做限制。
+// refreshtoken本身的有效期由TokenDB校验
+func (p *TokenPolicy) CheckRefresh(token *Token, now time.Time) error {
+	if p == nil {
+		return nil
+	}
+	if p.AbsoluteLifetime > 0 && !token.GetFamilyCreateAt().Add(p.AbsoluteLifetime).After(now) {
+		return ErrSessionExpired
+	}
+	if p.IdleTimeout > 0 && now.Sub(token.GetRefreshCreateAt()) > p.IdleTimeout {
+		return ErrRefreshIdle
+	}
+	if p.MaxRefreshCount > 0 && token.GetRefreshCount() >= p.MaxRefreshCount {
+		return ErrRefreshLimitExceeded
+	}
+	return nil
+}
+
+// SetTokenPolicy 设置租户的Token策略。appID为空时为租户默认策略，否则为租户对该App的覆盖；
+// policy为nil时删除对应的设置
+func (o *OAuth) SetTokenPolicy(appID string, policy *TokenPolicy) {
+	o.policyLock.Lock()
+	defer o.policyLock.Unlock()
+	if policy == nil {
+		delete(o.policies, appID)
+		return
+	}
+	o.policies[appID] = policy
+}
+
+// appTokenPolicy 同tokenPolicy，通过商户与AppID查找App，App不存在时只按租户的设置合并
+func (o *OAuth) appTokenPolicy(merchantID, appID string) *TokenPolicy {
+	app := &Application{AppID: appID}
+	if merchant, err := o.merchantDB.Read(merchantID); err == nil && merchant.HasApp(appID) {
+		app = merchant.GetApp(appID)
+	}
+	return o.tokenPolicy(app)
+}
+
+// tokenPolicy 返回App生效的Token策略，按字段合并，优先级从高到低：
+// 租户对App的覆盖、Application.TokenPolicy、租户默认策略
+func (o *OAuth) tokenPolicy(app *Application) *TokenPolicy {
+	o.policyLock.RLock()
+	defer o.policyLock.RUnlock()
+	return o.policies[app.AppID].merge(app.TokenPolicy.merge(o.policies[""]))
+}
@@ -0,0 +1,114 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenPolicy(t *testing.T) {
+
+	Convey("策略合并", t, func() {
+		o := newTestOAuth()
+		app := &Application{AppID: "AppID1", TokenPolicy: &TokenPolicy{AccessTTL: time.Minute, MaxRefreshCount: 3}}
+		So(o.tokenPolicy(app), ShouldResemble, &TokenPolicy{AccessTTL: time.Minute, MaxRefreshCount: 3})
+
+		o.SetTokenPolicy("", &TokenPolicy{AccessTTL: time.Hour, IdleTimeout: time.Hour})
+		So(o.tokenPolicy(app), ShouldResemble, &TokenPolicy{AccessTTL: time.Minute, MaxRefreshCount: 3, IdleTimeout: time.Hour})
+		So(o.tokenPolicy(&Application{AppID: "AppID2"}), ShouldResemble, &TokenPolicy{AccessTTL: time.Hour, IdleTimeout: time.Hour})
+
+		o.SetTokenPolicy("AppID1", &TokenPolicy{MaxRefreshCount: 1})
+		So(o.tokenPolicy(app), ShouldResemble, &TokenPolicy{AccessTTL: time.Minute, MaxRefreshCount: 1, IdleTimeout: time.Hour})
+
+		o.SetTokenPolicy("AppID1", nil)
+		o.SetTokenPolicy("", nil)
+		So(o.tokenPolicy(app), ShouldResemble, &TokenPolicy{AccessTTL: time.Minute, MaxRefreshCount: 3})
+	})
+
+	Convey("有效期", t, func() {
+		now := time.Now()
+		var policy *TokenPolicy
+		access, refresh := policy.Lifetimes(now, now)
+		So(access, ShouldEqual, TokenExpiry)
+		So(refresh, ShouldEqual, RefreshExpiry)
+
+		policy = &TokenPolicy{AccessTTL: time.Hour, RefreshTTL: 24 * time.Hour, AbsoluteLifetime: 2 * time.Hour}
+		access, refresh = policy.Lifetimes(now, now)
+		So(access, ShouldEqual, time.Hour)
+		So(refresh, ShouldEqual, 2*time.Hour)
+		access, refresh = policy.Lifetimes(now.Add(-90*time.Minute), now)
+		So(access, ShouldEqual, 30*time.Minute)
+		So(refresh, ShouldEqual, 30*time.Minute)
+	})
+
+	Convey("刷新校验", t, func() {
+		now := time.Now()
+		token := &Token{FamilyCreateAt: now.Add(-time.Hour), RefreshCreateAt: now.Add(-10 * time.Minute), RefreshCount: 2}
+		var policy *TokenPolicy
+		So(policy.CheckRefresh(token, now), ShouldBeNil)
+		So((&TokenPolicy{MaxRefreshCount: 3}).CheckRefresh(token, now), ShouldBeNil)
+		So((&TokenPolicy{MaxRefreshCount: 2}).CheckRefresh(token, now), ShouldEqual, ErrRefreshLimitExceeded)
+		So((&TokenPolicy{AbsoluteLifetime: time.Hour}).CheckRefresh(token, now), ShouldEqual, ErrSessionExpired)
+		So((&TokenPolicy{IdleTimeout: 5 * time.Minute}).CheckRefresh(token, now), ShouldEqual, ErrRefreshIdle)
+		So((&TokenPolicy{IdleTimeout: 15 * time.Minute, AbsoluteLifetime: 2 * time.Hour}).CheckRefresh(token, now), ShouldBeNil)
+	})
+
+	Convey("签发与刷新时执行策略", t, func() {
+		o := newTestOAuth()
+		merchant, err := o.MerchantDB().Read("Tencent")
+		So(err, ShouldBeNil)
+		merchant.GetApp("AppID1").TokenPolicy = &TokenPolicy{AccessTTL: time.Minute, MaxRefreshCount: 1, AbsoluteLifetime: time.Hour}
+		mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}
+
+		token, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		So(token.GetAccessExpiresIn(), ShouldEqual, time.Minute)
+		So(token.GetRefreshExpiresIn(), ShouldBeLessThanOrEqualTo, time.Hour)
+		So(token.GetRefreshCount(), ShouldEqual, 0)
+
+		refreshed, err := o.RefreshToken(mInfo, token.RefreshToken)
+		So(err, ShouldBeNil)
+		So(refreshed.GetRefreshCount(), ShouldEqual, 1)
+		So(refreshed.GetFamilyCreateAt(), ShouldEqual, token.GetFamilyCreateAt())
+		_, err = o.RefreshToken(mInfo, refreshed.RefreshToken)
+		So(err, ShouldEqual, ErrRefreshLimitExceeded)
+		// 达到上限后家族失效
+		So(o.VerifyToken(mInfo, refreshed.AccessToken), ShouldBeError)
+
+		// 租户覆盖App的策略
+		o.SetTokenPolicy("AppID1", &TokenPolicy{AccessTTL: 2 * time.Minute, MaxRefreshCount: 2})
+		token, err = issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		So(token.GetAccessExpiresIn(), ShouldEqual, 2*time.Minute)
+		token, err = o.RefreshToken(mInfo, token.RefreshToken)
+		So(err, ShouldBeNil)
+		_, err = o.RefreshToken(mInfo, token.RefreshToken)
+		So(err, ShouldBeNil)
+
+		// 租户默认策略作用于未设置策略的App
+		o.SetTokenPolicy("", &TokenPolicy{RefreshTTL: time.Hour})
+		token, err = issueTestToken(o, "AppID2")
+		So(err, ShouldBeNil)
+		So(token.GetAccessExpiresIn(), ShouldEqual, TokenExpiry)
+		So(token.GetRefreshExpiresIn(), ShouldEqual, time.Hour)
+	})
+
+	Convey("JWT模式使用策略中的有效期", t, func() {
+		issuer, err := NewJWTIssuer(privateKey, "saas", "tenant1")
+		So(err, ShouldBeNil)
+		o := newTestOAuth()
+		o.SetJWTIssuer(issuer)
+		token, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		So(token.GetAccessExpiresIn(), ShouldEqual, TokenExpiry)
+
+		o.SetTokenPolicy("AppID1", &TokenPolicy{AccessTTL: 3 * time.Minute})
+		token, err = issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		So(token.GetAccessExpiresIn(), ShouldEqual, 3*time.Minute)
+		claims, err := issuer.Parse(token.AccessToken)
+		So(err, ShouldBeNil)
+		So(claims.ExpiresAt-claims.IssuedAt, ShouldEqual, int64(180))
+	})
+}
@@ -0,0 +1,82 @@
+package oauth
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidScope 请求的scope超出App允许的范围
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrInsufficientScope accesstoken不具备所需的scope
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// Scopes 一组scope，字符串形式以空格分隔，如"orders:read orders:write users"。
+// scope按:分层，上层scope包含所有下层scope：
+// 1) orders 包含 orders、orders:read、orders:read:detail
+// 2) orders:* 包含 orders:read 等下层scope，不包含orders本身
+// 3) * 包含所有scope
+type Scopes []string
+
+// ParseScopes 解析以空白分隔的scope字符串，去除重复项
+func ParseScopes(scope string) Scopes {
+	scopes := Scopes{}
+	seen := map[string]bool{}
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// String 返回以空格分隔的scope字符串
+func (s Scopes) String() string {
+	return strings.Join(s, " ")
+}
+
+// Allows 判断是否包含required
+func (s Scopes) Allows(required string) bool {
+	for _, granted := range s {
+		if scopeAllows(granted, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAll 判断是否包含required中的全部scope
+func (s Scopes) AllowsAll(required Scopes) bool {
+	for _, r := range required {
+		if !s.Allows(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// NarrowScopes 在allowed范围内授予requested，requested为空时授予allowed全部scope；
+// requested超出allowed时返回ErrInvalidScope
+func NarrowScopes(allowed, requested Scopes) (Scopes, error) {
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	if !allowed.AllowsAll(requested) {
+		return nil, ErrInvalidScope
+	}
+	return requested, nil
+}
+
+// scopeAllows 判断granted是否包含required
+func scopeAllows(granted, required string) bool {
+	switch {
+	case granted == "*" || granted == required:
+		return true
+	case strings.HasSuffix(granted, ":*"):
+		return strings.HasPrefix(required, strings.TrimSuffix(granted, "*")) && len(required) > len(granted)-1
+	default:
+		return strings.HasPrefix(required, granted+":")
+	}
+}
@@ -0,0 +1,43 @@
+package oauth
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScopes(t *testing.T) {
+
+	Convey("ParseScopes", t, func() {
+		scopes := ParseScopes("  orders:read users\torders:read ")
+		So(scopes, ShouldResemble, Scopes{"orders:read", "users"})
+		So(scopes.String(), ShouldEqual, "orders:read users")
+		So(ParseScopes(""), ShouldBeEmpty)
+	})
+
+	Convey("Allows", t, func() {
+		So(Scopes{"orders"}.Allows("orders"), ShouldBeTrue)
+		So(Scopes{"orders"}.Allows("orders:read:detail"), ShouldBeTrue)
+		So(Scopes{"orders"}.Allows("ordersx"), ShouldBeFalse)
+		So(Scopes{"orders:*"}.Allows("orders:read"), ShouldBeTrue)
+		So(Scopes{"orders:*"}.Allows("orders"), ShouldBeFalse)
+		So(Scopes{"orders:read"}.Allows("orders"), ShouldBeFalse)
+		So(Scopes{"orders:read"}.Allows("orders:write"), ShouldBeFalse)
+		So(Scopes{"*"}.Allows("anything:at:all"), ShouldBeTrue)
+		So(Scopes{}.Allows("orders"), ShouldBeFalse)
+		So(Scopes{"orders", "users:read"}.AllowsAll(Scopes{"orders:write", "users:read"}), ShouldBeTrue)
+		So(Scopes{"orders", "users:read"}.AllowsAll(Scopes{"users:write"}), ShouldBeFalse)
+	})
+
+	Convey("NarrowScopes", t, func() {
+		allowed := ParseScopes("orders users:read")
+		granted, err := NarrowScopes(allowed, nil)
+		So(err, ShouldBeNil)
+		So(granted, ShouldResemble, allowed)
+		granted, err = NarrowScopes(allowed, ParseScopes("orders:read"))
+		So(err, ShouldBeNil)
+		So(granted, ShouldResemble, Scopes{"orders:read"})
+		_, err = NarrowScopes(allowed, ParseScopes("orders users"))
+		So(err, ShouldEqual, ErrInvalidScope)
+	})
+}
@@ -0,0 +1,412 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Handler挂载的接口路径
+const (
+	PathToken      = "/token"
+	PathVerify     = "/verify"
+	PathRevoke     = "/revoke"
+	PathIntrospect = "/introspect"
+	PathAuthorize  = "/authorize"
+)
+
+// /token接口支持的grant_type
+const (
+	GrantTypeMerchantSign = "merchant_signature" // 商户私钥签名，见SignMerchantInfo
+	GrantTypeRefreshToken = "refresh_token"
+)
+
+// TokenResponse 签发Token的响应(RFC 6749 5.1)
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"` // accesstoken有效期，单位秒
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// newTokenResponse 由Token生成响应
+func newTokenResponse(token *Token) *TokenResponse {
+	return &TokenResponse{
+		AccessToken:  token.GetAccessToken(),
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(token.GetAccessExpiresIn().Seconds()),
+		RefreshToken: token.GetRefreshToken(),
+		Scope:        token.GetScope(),
+	}
+}
+
+// VerifyResponse 验证Token的响应
+type VerifyResponse struct {
+	Active bool   `json:"active"`
+	Scope  string `json:"scope,omitempty"` // 授予的全部scope
+}
+
+// Handler 返回挂载了全部Token接口的http.Handler：
+// POST /token 签发与刷新Token，见TokenHandler；
+// POST /verify 验证Token，见VerifyHandler；
+// POST /revoke 吊销Token，见RevokeHandler；
+// POST /introspect Token内省，见IntrospectHandler；
+// GET|POST /authorize 授权码模式的授权接口，见AuthorizeHandler。
+// 所有错误均以ErrorResponse返回，多租户时可按租户用http.StripPrefix挂载，
+// 请求签名按改写前的完整路径校验，见crypt.ParseHTTPRequest
+func (o *OAuth) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(PathToken, o.TokenHandler())
+	mux.Handle(PathVerify, o.VerifyHandler())
+	mux.Handle(PathRevoke, o.RevokeHandler())
+	mux.Handle(PathIntrospect, o.IntrospectHandler())
+	mux.Handle(PathAuthorize, o.AuthorizeHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no such endpoint: "+r.URL.Path)
+	})
+	return mux
+}
+
+// TokenHandler 返回签发Token接口的http.Handler，请求为POST表单，按grant_type区分：
+// merchant_signature：参数merchant_id、app_id、timestamp、nonce、scope(可选)、sign，签名由SignMerchantInfo生成；
+// refresh_token：参数merchant_id、app_id、refresh_token，需由商户私钥通过crypt.SignHTTPRequest签名；
+// 不携带merchant_id时按客户端认证，见authenticateClient；
+// client_credentials：参数scope(可选)，客户端认证方式三选一：HTTP Basic(AppID:AppSecret)、
+// 表单client_id与client_secret、表单client_assertion_type与client_assertion(private_key_jwt)；
+// authorization_code：参数code、redirect_uri、code_verifier，未登记AppSecret的App只需提供client_id
+func (o *OAuth) TokenHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkFormPost(w, r) {
+			return
+		}
+		form, err := readForm(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err = checkParams(form, "grant_type"); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		switch form.Get("grant_type") {
+		case GrantTypeMerchantSign:
+			o.serveMerchantSign(w, form)
+		case GrantTypeRefreshToken:
+			o.serveRefreshToken(w, r, form)
+		case GrantTypeClientCredentials:
+			o.serveClientCredentials(w, r, form)
+		case GrantTypeAuthorizationCode:
+			o.serveAuthorizationCode(w, r, form)
+		default:
+			writeError(w, http.StatusBadRequest, ErrCodeUnsupportedGrantType, "unsupport grant_type: "+form.Get("grant_type"))
+		}
+	})
+}
+
+// serveMerchantSign 使用商户签名签发Token
+func (o *OAuth) serveMerchantSign(w http.ResponseWriter, form url.Values) {
+	if err := checkParams(form, "merchant_id", "app_id", "timestamp", "nonce", "sign"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	timestamp, err := strconv.ParseInt(form.Get("timestamp"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "timestamp invalid")
+		return
+	}
+	mInfo := &MerchantInfo{
+		MerchantID: form.Get("merchant_id"),
+		AppID:      form.Get("app_id"),
+		Timestamp:  timestamp,
+		Nonce:      form.Get("nonce"),
+		Scope:      form.Get("scope"),
+	}
+	token, err := o.IssueToken(mInfo, form.Get("sign"))
+	if errors.Is(err, ErrInvalidScope) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidScope, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, ErrCodeInvalidClient, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(token))
+}
+
+// serveRefreshToken 使用refreshtoken换取新的Token。携带merchant_id时需商户签名，
+// 否则按authenticateClient认证客户端，用于授权码模式签发的Token
+func (o *OAuth) serveRefreshToken(w http.ResponseWriter, r *http.Request, form url.Values) {
+	if err := checkParams(form, "refresh_token"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	mInfo := &MerchantInfo{MerchantID: form.Get("merchant_id"), AppID: form.Get("app_id")}
+	if mInfo.MerchantID == "" {
+		merchant, appID, ok := o.authenticateClient(w, r, form, true)
+		if !ok {
+			return
+		}
+		mInfo = &MerchantInfo{MerchantID: merchant.MerchantID, AppID: appID}
+	} else if err := checkParams(form, "app_id"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	} else if err := o.VerifyMerchantHTTPRequest(mInfo.MerchantID, r); err != nil {
+		writeError(w, http.StatusUnauthorized, ErrCodeInvalidClient, err.Error())
+		return
+	}
+	token, err := o.RefreshToken(mInfo, form.Get("refresh_token"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidGrant, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(token))
+}
+
+// serveClientCredentials 认证客户端后签发Token
+func (o *OAuth) serveClientCredentials(w http.ResponseWriter, r *http.Request, form url.Values) {
+	merchant, appID, ok := o.authenticateClient(w, r, form, false)
+	if !ok {
+		return
+	}
+	token, err := o.issueClientToken(merchant, appID, form.Get("scope"))
+	if errors.Is(err, ErrInvalidScope) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidScope, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(token))
+}
+
+// serveAuthorizationCode 使用授权码换取Token
+func (o *OAuth) serveAuthorizationCode(w http.ResponseWriter, r *http.Request, form url.Values) {
+	if err := checkParams(form, "code", "redirect_uri", "code_verifier"); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	_, appID, ok := o.authenticateClient(w, r, form, true)
+	if !ok {
+		return
+	}
+	token, err := o.ExchangeCode(appID, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidGrant, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newTokenResponse(token))
+}
+
+// authenticateClient 认证/token接口的客户端，认证方式三选一：HTTP Basic(AppID:AppSecret)、
+// 表单client_id与client_secret、表单client_assertion_type与client_assertion(private_key_jwt)；
+// allowPublic为true时未登记AppSecret的App只需提供client_id。认证失败时输出错误响应并返回false
+func (o *OAuth) authenticateClient(w http.ResponseWriter, r *http.Request, form url.Values, allowPublic bool) (*Merchant, string, bool) {
+	basicID, basicSecret, basic := r.BasicAuth()
+	methods := 0
+	for _, used := range []bool{basic, form.Has("client_secret"), form.Has("client_assertion")} {
+		if used {
+			methods++
+		}
+	}
+	if methods > 1 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "multiple client authentication methods")
+		return nil, "", false
+	}
+
+	var merchant *Merchant
+	var appID string
+	var err error
+	switch {
+	case basic:
+		// RFC 6749 2.3.1：client_id与client_secret在Basic认证前经过表单编码
+		var appSecret string
+		var err1, err2 error
+		appID, err1 = url.QueryUnescape(basicID)
+		appSecret, err2 = url.QueryUnescape(basicSecret)
+		if err1 != nil || err2 != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "basic credentials invalid")
+			return nil, "", false
+		}
+		merchant, err = o.AuthenticateClientSecret(appID, appSecret)
+	case form.Has("client_secret"):
+		if err = checkParams(form, "client_id"); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return nil, "", false
+		}
+		appID = form.Get("client_id")
+		merchant, err = o.AuthenticateClientSecret(appID, form.Get("client_secret"))
+	case form.Has("client_assertion"):
+		if form.Get("client_assertion_type") != ClientAssertionTypeJWT {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "unsupport client_assertion_type")
+			return nil, "", false
+		}
+		merchant, appID, err = o.AuthenticateClientAssertion(form.Get("client_id"), form.Get("client_assertion"))
+	case allowPublic && form.Get("client_id") != "":
+		appID = form.Get("client_id")
+		merchant, err = o.authenticatePublicClient(appID)
+	default:
+		err = ErrInvalidClient
+	}
+	if err == nil && form.Get("client_id") != "" && form.Get("client_id") != appID {
+		err = fmt.Errorf("%w: client_id mismatch", ErrInvalidClient)
+	}
+	if err != nil {
+		if basic || methods == 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeError(w, http.StatusUnauthorized, ErrCodeInvalidClient, err.Error())
+		return nil, "", false
+	}
+	return merchant, appID, true
+}
+
+// AuthorizeHandler 返回授权码模式授权接口的http.Handler，支持GET与POST表单，
+// 参数response_type=code、client_id、redirect_uri、scope(可选)、state(可选)、
+// code_challenge、code_challenge_method=S256。
+// 参数校验通过后调用SetConsent设置的回调征得商户同意，同意后携带code与state重定向到redirect_uri；
+// client_id或redirect_uri无效时不重定向，直接返回ErrorResponse
+func (o *OAuth) AuthorizeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, ErrCodeInvalidRequest, "method not allowed")
+			return
+		}
+		if o.consent == nil {
+			writeError(w, http.StatusNotFound, ErrCodeNotFound, "authorization code grant not enabled")
+			return
+		}
+		if r.Method == http.MethodPost && !checkFormPost(w, r) {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		req, err := o.ParseAuthorizeRequest(r.Form)
+		if err != nil {
+			var authErr *AuthorizeError
+			if !errors.As(err, &authErr) {
+				writeError(w, http.StatusInternalServerError, ErrCodeServerError, "")
+				return
+			}
+			if !authErr.Redirect {
+				writeError(w, http.StatusBadRequest, authErr.Code, authErr.Description)
+				return
+			}
+			redirectAuthorize(w, r, req, url.Values{"error": {authErr.Code}, "error_description": {authErr.Description}})
+			return
+		}
+		consent, err := o.consent(w, r, req)
+		if errors.Is(err, ErrAccessDenied) {
+			redirectAuthorize(w, r, req, url.Values{"error": {ErrCodeAccessDenied}})
+			return
+		}
+		if err != nil {
+			redirectAuthorize(w, r, req, url.Values{"error": {ErrCodeServerError}})
+			return
+		}
+		if consent == nil {
+			return
+		}
+		code, err := o.Authorize(req, consent)
+		if errors.Is(err, ErrInvalidScope) {
+			redirectAuthorize(w, r, req, url.Values{"error": {ErrCodeInvalidScope}, "error_description": {err.Error()}})
+			return
+		}
+		if err != nil {
+			redirectAuthorize(w, r, req, url.Values{"error": {ErrCodeAccessDenied}, "error_description": {err.Error()}})
+			return
+		}
+		redirectAuthorize(w, r, req, url.Values{"code": {code}})
+	})
+}
+
+// redirectAuthorize 携带参数与state重定向到回调地址，保留回调地址中原有的query
+func redirectAuthorize(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeServerError, "")
+		return
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// VerifyHandler 返回验证Token接口的http.Handler，供资源服务调用。
+// 请求为POST表单，参数merchant_id、app_id、scope(可选，要求具备的scope，以空格分隔)，
+// accesstoken通过Authorization: Bearer请求头传递。
+// Token无效时返回401 invalid_token，scope不足时返回403 insufficient_scope
+func (o *OAuth) VerifyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkFormPost(w, r) {
+			return
+		}
+		form, err := readForm(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		if err = checkParams(form, "merchant_id", "app_id"); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+		accessToken, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			writeError(w, http.StatusUnauthorized, ErrCodeInvalidRequest, "bearer token missing")
+			return
+		}
+		mInfo := &MerchantInfo{MerchantID: form.Get("merchant_id"), AppID: form.Get("app_id")}
+		granted, err := o.VerifyTokenScopes(mInfo, accessToken, ParseScopes(form.Get("scope"))...)
+		if errors.Is(err, ErrInsufficientScope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, scope=%q`, ErrCodeInsufficientScope, form.Get("scope")))
+			writeError(w, http.StatusForbidden, ErrCodeInsufficientScope, err.Error())
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q`, ErrCodeInvalidToken))
+			writeError(w, http.StatusUnauthorized, ErrCodeInvalidToken, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, &VerifyResponse{Active: true, Scope: granted.String()})
+	})
+}
+
+// bearerToken 从Authorization请求头中取出Bearer Token
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, token != ""
+}
+
+// checkParams 校验表单参数：required中的参数必须存在且非空，所有参数不能重复出现(RFC 6749 3.2)
+func checkParams(form url.Values, required ...string) error {
+	for _, name := range required {
+		if form.Get(name) == "" {
+			return fmt.Errorf("parameter %s missing", name)
+		}
+	}
+	for name, values := range form {
+		if len(values) > 1 {
+			return fmt.Errorf("parameter %s repeated", name)
+		}
+	}
+	return nil
+}
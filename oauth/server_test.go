@@ -0,0 +1,326 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"saas/crypt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// signTokenForm 生成商户签名方式签发Token的表单
+func signTokenForm(appID, scope string) (url.Values, error) {
+	mInfo := &MerchantInfo{MerchantID: "Tencent", AppID: appID, Scope: scope}
+	sign, err := SignMerchantInfo(privateKey, mInfo)
+	if err != nil {
+		return nil, err
+	}
+	return url.Values{
+		"grant_type":  {GrantTypeMerchantSign},
+		"merchant_id": {mInfo.MerchantID},
+		"app_id":      {mInfo.AppID},
+		"timestamp":   {strconv.FormatInt(mInfo.Timestamp, 10)},
+		"nonce":       {mInfo.Nonce},
+		"scope":       {mInfo.Scope},
+		"sign":        {sign},
+	}, nil
+}
+
+// serve 发送请求并返回响应
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// errorCode 解析错误响应中的错误码
+func errorCode(w *httptest.ResponseRecorder) string {
+	errResp := &ErrorResponse{}
+	json.Unmarshal(w.Body.Bytes(), errResp)
+	return errResp.Error
+}
+
+func TestServer(t *testing.T) {
+
+	Convey("签发与刷新Token", t, func() {
+		o := newTestOAuth()
+		h := o.Handler()
+
+		form, err := signTokenForm("AppID1", "orders")
+		So(err, ShouldBeNil)
+		w := serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+		resp := &TokenResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+		So(resp.TokenType, ShouldEqual, "Bearer")
+		So(resp.ExpiresIn, ShouldEqual, int64(TokenExpiry.Seconds()))
+		So(resp.Scope, ShouldEqual, "orders")
+		So(resp.RefreshToken, ShouldNotBeEmpty)
+		So(o.VerifyToken(&MerchantInfo{MerchantID: "Tencent", AppID: "AppID1"}, resp.AccessToken), ShouldBeNil)
+
+		// 重放
+		w = serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidClient)
+
+		// 刷新需要签名
+		refreshForm := url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"merchant_id":   {"Tencent"},
+			"app_id":        {"AppID1"},
+			"refresh_token": {resp.RefreshToken},
+		}
+		w = serve(h, newFormRequest(PathToken, refreshForm))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidClient)
+
+		r := newFormRequest(PathToken, refreshForm)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		refreshed := &TokenResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), refreshed), ShouldBeNil)
+		So(refreshed.AccessToken, ShouldNotEqual, resp.AccessToken)
+		So(refreshed.Scope, ShouldEqual, "orders")
+
+		// 已轮换的refreshtoken
+		r = newFormRequest(PathToken, refreshForm)
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidGrant)
+	})
+
+	Convey("请求校验", t, func() {
+		o := newTestOAuth()
+		h := o.Handler()
+
+		form, err := signTokenForm("AppID2", "users:read")
+		So(err, ShouldBeNil)
+		w := serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidScope)
+
+		w = serve(h, newFormRequest(PathToken, url.Values{"grant_type": {"password"}}))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeUnsupportedGrantType)
+
+		w = serve(h, newFormRequest(PathToken, url.Values{"grant_type": {GrantTypeMerchantSign}, "merchant_id": {"Tencent"}}))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		form, err = signTokenForm("AppID1", "")
+		So(err, ShouldBeNil)
+		form.Set("timestamp", "now")
+		w = serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		form, err = signTokenForm("AppID1", "")
+		So(err, ShouldBeNil)
+		form.Add("app_id", "AppID2")
+		w = serve(h, newFormRequest(PathToken, form))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		r := httptest.NewRequest(http.MethodPost, PathToken, strings.NewReader(`{"grant_type":"refresh_token"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		w = serve(h, httptest.NewRequest(http.MethodGet, PathToken, nil))
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		So(w.Header().Get("Allow"), ShouldEqual, http.MethodPost)
+
+		w = serve(h, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+		So(w.Code, ShouldEqual, http.StatusNotFound)
+		So(errorCode(w), ShouldEqual, ErrCodeNotFound)
+	})
+
+	Convey("验证与吊销Token", t, func() {
+		o := newTestOAuth()
+		h := o.Handler()
+		token, err := issueTestToken(o, "AppID1")
+		So(err, ShouldBeNil)
+		form := url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}, "scope": {"users:read"}}
+
+		r := newFormRequest(PathVerify, form)
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		w := serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		resp := &VerifyResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+		So(resp.Active, ShouldBeTrue)
+		So(resp.Scope, ShouldEqual, "orders users:read")
+
+		form.Set("scope", "users:write")
+		r = newFormRequest(PathVerify, form)
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+		So(errorCode(w), ShouldEqual, ErrCodeInsufficientScope)
+		So(w.Header().Get("WWW-Authenticate"), ShouldContainSubstring, ErrCodeInsufficientScope)
+
+		w = serve(h, newFormRequest(PathVerify, form))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		r = newFormRequest(PathRevoke, url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}, "token": {token.AccessToken}})
+		So(crypt.SignHTTPRequest(privateKey, r), ShouldBeNil)
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+
+		form.Del("scope")
+		r = newFormRequest(PathVerify, form)
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidToken)
+	})
+}
+
+func TestServerClientCredentials(t *testing.T) {
+
+	Convey("client_credentials授权", t, func() {
+		o := newTestOAuth()
+		o.SetAssertionAudience("https://auth.example.com/token")
+		h := o.Handler()
+		form := url.Values{"grant_type": {GrantTypeClientCredentials}, "scope": {"orders"}}
+
+		r := newFormRequest(PathToken, form)
+		r.SetBasicAuth("AppID1", "AppID1Secret")
+		w := serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusOK)
+		resp := &TokenResponse{}
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+		So(resp.Scope, ShouldEqual, "orders")
+		So(resp.RefreshToken, ShouldBeEmpty)
+
+		r = newFormRequest(PathToken, form)
+		r.SetBasicAuth("AppID1", "wrong")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidClient)
+		So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, "Basic")
+
+		postForm := url.Values{"grant_type": {GrantTypeClientCredentials}, "client_id": {"AppID2"}, "client_secret": {"AppID2Secret"}}
+		w = serve(h, newFormRequest(PathToken, postForm))
+		So(w.Code, ShouldEqual, http.StatusOK)
+
+		// 不能同时使用多种认证方式
+		r = newFormRequest(PathToken, postForm)
+		r.SetBasicAuth("AppID2", "AppID2Secret")
+		w = serve(h, r)
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+
+		w = serve(h, newFormRequest(PathToken, url.Values{"grant_type": {GrantTypeClientCredentials}}))
+		So(w.Code, ShouldEqual, http.StatusUnauthorized)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidClient)
+
+		assertion, err := SignClientAssertion(privateKey, "AppID1", "https://auth.example.com/token")
+		So(err, ShouldBeNil)
+		assertForm := url.Values{
+			"grant_type":            {GrantTypeClientCredentials},
+			"client_assertion_type": {ClientAssertionTypeJWT},
+			"client_assertion":      {assertion},
+			"scope":                 {"users:write"},
+		}
+		w = serve(h, newFormRequest(PathToken, assertForm))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidScope)
+
+		assertion, err = SignClientAssertion(privateKey, "AppID1", "https://auth.example.com/token")
+		So(err, ShouldBeNil)
+		assertForm.Set("client_assertion", assertion)
+		assertForm.Set("scope", "users:read")
+		w = serve(h, newFormRequest(PathToken, assertForm))
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(json.Unmarshal(w.Body.Bytes(), resp), ShouldBeNil)
+		So(resp.Scope, ShouldEqual, "users:read")
+
+		assertForm.Set("client_assertion_type", "urn:example:unknown")
+		w = serve(h, newFormRequest(PathToken, assertForm))
+		So(w.Code, ShouldEqual, http.StatusBadRequest)
+		So(errorCode(w), ShouldEqual, ErrCodeInvalidRequest)
+	})
+}
+
+func TestServerConcurrent(t *testing.T) {
+
+	Convey("并发请求", t, func() {
+		o := newTestOAuth()
+		h := o.Handler()
+		// 每个goroutine依次签发、验证、刷新、吊销Token，返回不符合预期的响应码
+		run := func() []int {
+			var failed []int
+			form, err := signTokenForm("AppID1", "orders")
+			if err != nil {
+				return []int{0}
+			}
+			w := serve(h, newFormRequest(PathToken, form))
+			if w.Code != http.StatusOK {
+				return []int{w.Code}
+			}
+			resp := &TokenResponse{}
+			json.Unmarshal(w.Body.Bytes(), resp)
+
+			r := newFormRequest(PathVerify, url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}})
+			r.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+			if w = serve(h, r); w.Code != http.StatusOK {
+				failed = append(failed, w.Code)
+			}
+
+			r = newFormRequest(PathToken, url.Values{
+				"grant_type":    {GrantTypeRefreshToken},
+				"merchant_id":   {"Tencent"},
+				"app_id":        {"AppID1"},
+				"refresh_token": {resp.RefreshToken},
+			})
+			crypt.SignHTTPRequest(privateKey, r)
+			if w = serve(h, r); w.Code != http.StatusOK {
+				failed = append(failed, w.Code)
+			}
+			json.Unmarshal(w.Body.Bytes(), resp)
+
+			r = newFormRequest(PathRevoke, url.Values{"merchant_id": {"Tencent"}, "app_id": {"AppID1"}, "token": {resp.AccessToken}})
+			crypt.SignHTTPRequest(privateKey, r)
+			if w = serve(h, r); w.Code != http.StatusOK {
+				failed = append(failed, w.Code)
+			}
+			return failed
+		}
+
+		const workers = 16
+		results := make(chan []int, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- run()
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < workers; i++ {
+				o.SetTokenPolicy("AppID1", &TokenPolicy{MaxRefreshCount: 10})
+				o.SetTokenPolicy("AppID1", nil)
+			}
+		}()
+		wg.Wait()
+		close(results)
+		for failed := range results {
+			So(failed, ShouldBeEmpty)
+		}
+	})
+}
@@ -6,14 +6,21 @@ import (
 )
 
 // Token 定义Token的所有属性
+// AccessToken、RefreshToken明文只在签发时返回给调用方，不参与序列化，
+// DB中以TokenHasher计算的AccessHash、RefreshHash为索引
 type Token struct {
+	MerchantID       string        `json:"merchant_id"`
 	AppID            string        `json:"appid"`
-	AppSecret        string        `json:"app_secret"`
 	Scope            string        `json:"scope"`
-	AccessToken      string        `json:"access"`
+	FamilyID         string        `json:"family_id"`        // 同一次授权及其后续刷新得到的Token属于同一家族
+	FamilyCreateAt   time.Time     `json:"family_create_at"` // 家族首次签发的时间
+	RefreshCount     int           `json:"refresh_count"`    // 家族已刷新的次数
+	AccessToken      string        `json:"-"`
+	AccessHash       string        `json:"access_hash"`
 	AccessCreateAt   time.Time     `json:"access_create_at"`
 	AccessExpiresIn  time.Duration `json:"access_expires_in"`
-	RefreshToken     string        `json:"refresh"`
+	RefreshToken     string        `json:"-"`
+	RefreshHash      string        `json:"refresh_hash"`
 	RefreshCreateAt  time.Time     `json:"refresh_create_at"`
 	RefreshExpiresIn time.Duration `json:"refresh_expires_in"`
 }
@@ -23,6 +30,66 @@ func NewToken() *Token {
 	return &Token{}
 }
 
+// GetMerchantID 获取MerchantID
+func (t *Token) GetMerchantID() string {
+	return t.MerchantID
+}
+
+// SetMerchantID 设置MerchantID
+func (t *Token) SetMerchantID(merchantID string) {
+	t.MerchantID = merchantID
+}
+
+// GetAppID 获取AppID
+func (t *Token) GetAppID() string {
+	return t.AppID
+}
+
+// SetAppID 设置AppID
+func (t *Token) SetAppID(appID string) {
+	t.AppID = appID
+}
+
+// GetScope 获取Scope
+func (t *Token) GetScope() string {
+	return t.Scope
+}
+
+// SetScope 设置Scope
+func (t *Token) SetScope(scope string) {
+	t.Scope = scope
+}
+
+// GetFamilyID 获取Token家族ID
+func (t *Token) GetFamilyID() string {
+	return t.FamilyID
+}
+
+// SetFamilyID 设置Token家族ID
+func (t *Token) SetFamilyID(familyID string) {
+	t.FamilyID = familyID
+}
+
+// GetFamilyCreateAt 获取Token家族首次签发的时间
+func (t *Token) GetFamilyCreateAt() time.Time {
+	return t.FamilyCreateAt
+}
+
+// SetFamilyCreateAt 设置Token家族首次签发的时间
+func (t *Token) SetFamilyCreateAt(createAt time.Time) {
+	t.FamilyCreateAt = createAt
+}
+
+// GetRefreshCount 获取Token家族已刷新的次数
+func (t *Token) GetRefreshCount() int {
+	return t.RefreshCount
+}
+
+// SetRefreshCount 设置Token家族已刷新的次数
+func (t *Token) SetRefreshCount(count int) {
+	t.RefreshCount = count
+}
+
 // GetAccessToken 获取AccessToken
 func (t *Token) GetAccessToken() string {
 	return t.AccessToken
@@ -33,6 +100,16 @@ func (t *Token) SetAccessToken(accessToken string) {
 	t.AccessToken = accessToken
 }
 
+// GetAccessHash 获取AccessToken的哈希
+func (t *Token) GetAccessHash() string {
+	return t.AccessHash
+}
+
+// SetAccessHash 设置AccessToken的哈希
+func (t *Token) SetAccessHash(accessHash string) {
+	t.AccessHash = accessHash
+}
+
 // GetAccessCreateAt 获取AccessToken的创建时间
 func (t *Token) GetAccessCreateAt() time.Time {
 	return t.AccessCreateAt
@@ -63,6 +140,16 @@ func (t *Token) SetRefreshToken(refresh string) {
 	t.RefreshToken = refresh
 }
 
+// GetRefreshHash 获取RefreshToken的哈希
+func (t *Token) GetRefreshHash() string {
+	return t.RefreshHash
+}
+
+// SetRefreshHash 设置RefreshToken的哈希
+func (t *Token) SetRefreshHash(refreshHash string) {
+	t.RefreshHash = refreshHash
+}
+
 // GetRefreshCreateAt 获取RefreshToken的创建时间
 func (t *Token) GetRefreshCreateAt() time.Time {
 	return t.RefreshCreateAt
@@ -83,6 +170,13 @@ func (t *Token) SetRefreshExpiresIn(exp time.Duration) {
 	t.RefreshExpiresIn = exp
 }
 
+// redact 返回不含Token明文的副本，用于存储
+func (t *Token) redact() *Token {
+	token := *t
+	token.AccessToken, token.RefreshToken = "", ""
+	return &token
+}
+
 // Prettify 格式化输出,便于调试
 func (t *Token) Prettify() string {
 	str, _ := json.MarshalIndent(t, "", "    ")
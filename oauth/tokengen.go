@@ -0,0 +1,135 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
+)
+
+// 常量定义
+const (
+	// Token类型前缀
+	TokenTypeAccess    = "at"
+	TokenTypeRefresh   = "rt"
+	TokenTypeAppSecret = "cs"
+
+	// Token随机部分的编码方式
+	TokenEncodingBase62    = "base62"
+	TokenEncodingBase64URL = "base64url"
+
+	// DefaultTokenPrefix 默认的服务前缀，便于密钥扫描工具识别
+	DefaultTokenPrefix = "saas"
+
+	base62Alphabet  = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	checksumLength  = 6 // 62^6 > 2^32，可以容纳CRC32
+	minTokenEntropy = 16
+)
+
+// TokenGenerator 基于crypto/rand的Token生成器。生成的Token格式为：
+// [prefix_]type_[tenant_]<随机部分><6位base62的CRC32校验和>
+// 校验和覆盖前面的全部内容，泄露的Token可以被密钥扫描工具离线识别，见VerifyTokenChecksum
+type TokenGenerator struct {
+	Entropy  int    // 随机部分的字节数，不少于16
+	Encoding string // 随机部分的编码方式，TokenEncodingBase62或TokenEncodingBase64URL
+	Prefix   string // 服务前缀，为空时不添加
+	Tenant   string // 租户标识，为空时不添加
+}
+
+// NewTokenGenerator 生成默认的TokenGenerator实例：32字节熵、base62编码、saas前缀
+func NewTokenGenerator() *TokenGenerator {
+	return &TokenGenerator{
+		Entropy:  32,
+		Encoding: TokenEncodingBase62,
+		Prefix:   DefaultTokenPrefix,
+	}
+}
+
+// Generate 生成指定类型的Token，tokenType如TokenTypeAccess、TokenTypeRefresh
+func (g *TokenGenerator) Generate(tokenType string) (string, error) {
+	if g.Entropy < minTokenEntropy {
+		return "", fmt.Errorf("token entropy too small: %d", g.Entropy)
+	}
+	parts := []string{}
+	for _, p := range []string{g.Prefix, tokenType, g.Tenant} {
+		if p == "" {
+			continue
+		}
+		if !isBase62(p) {
+			return "", fmt.Errorf("token prefix invalid: %s", p)
+		}
+		parts = append(parts, p)
+	}
+	if tokenType == "" {
+		return "", fmt.Errorf("token type invalid: %s", tokenType)
+	}
+
+	var body string
+	var err error
+	switch g.Encoding {
+	case TokenEncodingBase62:
+		body, err = randomBase62(int(math.Ceil(float64(g.Entropy*8) / math.Log2(62))))
+	case TokenEncodingBase64URL:
+		buf := make([]byte, g.Entropy)
+		if _, err = rand.Read(buf); err == nil {
+			body = base64.RawURLEncoding.EncodeToString(buf)
+		}
+	default:
+		return "", fmt.Errorf("unsupport token encoding: %v", g.Encoding)
+	}
+	if err != nil {
+		return "", err
+	}
+	token := strings.Join(append(parts, body), "_")
+	return token + tokenChecksum(token), nil
+}
+
+// VerifyTokenChecksum 校验Token末尾的校验和，只能识别Token格式，不代表Token有效
+func VerifyTokenChecksum(token string) bool {
+	if len(token) <= checksumLength {
+		return false
+	}
+	n := len(token) - checksumLength
+	return tokenChecksum(token[:n]) == token[n:]
+}
+
+// tokenChecksum 计算CRC32并编码为定长6位base62
+func tokenChecksum(s string) string {
+	sum := crc32.ChecksumIEEE([]byte(s))
+	buf := make([]byte, checksumLength)
+	for i := checksumLength - 1; i >= 0; i-- {
+		buf[i] = base62Alphabet[sum%62]
+		sum /= 62
+	}
+	return string(buf)
+}
+
+// randomBase62 生成n位均匀分布的base62随机串，通过拒绝采样避免取模偏差
+func randomBase62(n int) (string, error) {
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// 248 = 62*4，丢弃248及以上的值
+			if b < 248 && len(out) < n {
+				out = append(out, base62Alphabet[b%62])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// isBase62 判断字符串是否只包含base62字符
+func isBase62(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(base62Alphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
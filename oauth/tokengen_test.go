@@ -0,0 +1,68 @@
+package oauth
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenGenerator(t *testing.T) {
+
+	Convey("Generate base62", t, func() {
+		g := NewTokenGenerator()
+		token, err := g.Generate(TokenTypeAccess)
+		So(err, ShouldBeNil)
+		So(token, ShouldStartWith, "saas_at_")
+		// 32字节熵需要43位base62
+		So(len(token), ShouldEqual, len("saas_at_")+43+checksumLength)
+		So(VerifyTokenChecksum(token), ShouldBeTrue)
+		t.Logf("token:%s", token)
+
+		other, err := g.Generate(TokenTypeAccess)
+		So(err, ShouldBeNil)
+		So(other, ShouldNotEqual, token)
+	})
+
+	Convey("Generate base64url with tenant", t, func() {
+		g := &TokenGenerator{Entropy: 16, Encoding: TokenEncodingBase64URL, Tenant: "tenant1"}
+		token, err := g.Generate(TokenTypeRefresh)
+		So(err, ShouldBeNil)
+		So(token, ShouldStartWith, "rt_tenant1_")
+		So(len(token), ShouldEqual, len("rt_tenant1_")+22+checksumLength)
+		So(VerifyTokenChecksum(token), ShouldBeTrue)
+	})
+
+	Convey("VerifyTokenChecksum", t, func() {
+		token, err := NewTokenGenerator().Generate(TokenTypeAccess)
+		So(err, ShouldBeNil)
+		tampered := strings.Replace(token, "saas_at", "saas_rt", 1)
+		So(VerifyTokenChecksum(tampered), ShouldBeFalse)
+		So(VerifyTokenChecksum("short"), ShouldBeFalse)
+		So(VerifyTokenChecksum(RandomToken()), ShouldBeFalse)
+	})
+
+	Convey("Generate invalid options", t, func() {
+		_, err := (&TokenGenerator{Entropy: 8, Encoding: TokenEncodingBase62}).Generate(TokenTypeAccess)
+		So(err, ShouldBeError)
+		_, err = (&TokenGenerator{Entropy: 32, Encoding: "hex"}).Generate(TokenTypeAccess)
+		So(err, ShouldBeError)
+		_, err = (&TokenGenerator{Entropy: 32, Encoding: TokenEncodingBase62, Tenant: "a_b"}).Generate(TokenTypeAccess)
+		So(err, ShouldBeError)
+		_, err = NewTokenGenerator().Generate("")
+		So(err, ShouldBeError)
+	})
+
+	Convey("BackendTokenDB uses TokenGenerator", t, func() {
+		tdb := NewBackendTokenDB()
+		tdb.SetTokenGenerator(&TokenGenerator{Entropy: 32, Encoding: TokenEncodingBase62, Prefix: "saas", Tenant: "t1"})
+		token, err := tdb.CreateToken("Tencent", "AppID1", "AppID1Scope", nil)
+		So(err, ShouldBeNil)
+		So(token.AccessToken, ShouldStartWith, "saas_at_t1_")
+		So(token.RefreshToken, ShouldStartWith, "saas_rt_t1_")
+		newToken, err := tdb.RefreshToken("Tencent", "AppID1", token.RefreshToken, nil)
+		So(err, ShouldBeNil)
+		So(VerifyTokenChecksum(newToken.AccessToken), ShouldBeTrue)
+		So(VerifyTokenChecksum(newToken.RefreshToken), ShouldBeTrue)
+	})
+}
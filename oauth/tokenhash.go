@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"saas/crypt"
+)
+
+// TokenHasher 计算Token的keyed hash(HMAC-SHA256)，作为TokenDB中的索引。
+// 哈希密钥与DB分开保存，DB泄露后无法用哈希反查或伪造Token
+type TokenHasher struct {
+	key     []byte
+	km      crypt.KeyManager // 不为nil时使用KeyManager中的密钥，支持轮换
+	keyName string
+}
+
+// NewTokenHasher 生成TokenHasher实例，key至少16字节，可以由crypt.KeyDeriver派生
+func NewTokenHasher(key []byte) (*TokenHasher, error) {
+	if len(key) < 16 {
+		return nil, fmt.Errorf("token hash key too short: %d", len(key))
+	}
+	return &TokenHasher{key: append([]byte(nil), key...)}, nil
+}
+
+// NewTokenHasherWithKeyManager 生成使用KeyManager中加密密钥name的TokenHasher实例。
+// 新Token使用主版本计算哈希；轮换后老Token仍按未退役的版本查找，版本退役后由其签发的Token失效
+func NewTokenHasherWithKeyManager(km crypt.KeyManager, name string) (*TokenHasher, error) {
+	versions, err := km.Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, crypt.ErrKeyNotFound
+	}
+	// 校验密钥用途
+	if _, err = km.HMAC(versions[0], nil); err != nil {
+		return nil, err
+	}
+	return &TokenHasher{km: km, keyName: name}, nil
+}
+
+// Hash 计算Token的哈希，用于保存新Token，结果为小写hex
+func (h *TokenHasher) Hash(token string) (string, error) {
+	if h.km == nil {
+		return h.hash(token), nil
+	}
+	versions, err := h.km.Versions(h.keyName)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", crypt.ErrKeyNotFound
+	}
+	return h.hashWith(versions[0], token)
+}
+
+// Hashes 返回Token在所有可用密钥版本下的哈希，主版本在前，用于查找已保存的Token
+func (h *TokenHasher) Hashes(token string) ([]string, error) {
+	if h.km == nil {
+		return []string{h.hash(token)}, nil
+	}
+	versions, err := h.km.Versions(h.keyName)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(versions))
+	for _, keyID := range versions {
+		hash, err := h.hashWith(keyID, token)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// hash 使用固定的哈希密钥计算HMAC-SHA256
+func (h *TokenHasher) hash(token string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashWith 使用KeyManager中的密钥版本计算HMAC-SHA256
+func (h *TokenHasher) hashWith(keyID, token string) (string, error) {
+	sum, err := h.km.HMAC(keyID, []byte(token))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
@@ -0,0 +1,33 @@
+package oauth
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenHasher(t *testing.T) {
+
+	Convey("TokenHasher", t, func() {
+		_, err := NewTokenHasher([]byte("short"))
+		So(err, ShouldBeError)
+
+		h1, err := NewTokenHasher([]byte("0123456789abcdef"))
+		So(err, ShouldBeNil)
+		h2, err := NewTokenHasher([]byte("fedcba9876543210"))
+		So(err, ShouldBeNil)
+		hash := func(h *TokenHasher, token string) string {
+			sum, err := h.Hash(token)
+			So(err, ShouldBeNil)
+			return sum
+		}
+		So(hash(h1, "token"), ShouldEqual, hash(h1, "token"))
+		So(hash(h1, "token"), ShouldNotEqual, hash(h1, "token2"))
+		// 不同的哈希密钥得到不同的哈希
+		So(hash(h1, "token"), ShouldNotEqual, hash(h2, "token"))
+		So(hash(h1, "token"), ShouldHaveLength, 64)
+		hashes, err := h1.Hashes("token")
+		So(err, ShouldBeNil)
+		So(hashes, ShouldResemble, []string{hash(h1, "token")})
+	})
+}